@@ -0,0 +1,123 @@
+package mdast_test
+
+import (
+	"testing"
+
+	"github.com/rtfb/mdast-playground"
+)
+
+func mustParse(t *testing.T, input string) *mdast.Node {
+	t.Helper()
+	doc, err := mdast.Parse([]byte(input), mdast.Options{})
+	if err != nil {
+		t.Fatalf("parse %q: %v", input, err)
+	}
+	return doc
+}
+
+func TestTreeAccessors(t *testing.T) {
+	doc := mustParse(t, "# a\n\nb *c*\n\n---\n")
+	header, hr := doc.FirstChild(), doc.LastChild()
+	para := header.Next()
+	if doc.Parent() != nil || header.Prev() != nil || hr.Next() != nil {
+		t.Errorf("links beyond the ends of the tree are not nil")
+	}
+	if para.Parent() != doc || para.Prev() != header || para.Next() != hr || hr.Prev() != para {
+		t.Errorf("sibling and parent links are inconsistent")
+	}
+	if header.Type != mdast.Header || header.Level() != 1 {
+		t.Errorf("first child is %s of level %d, want Header of level 1", header.Type, header.Level())
+	}
+	emph := para.LastChild()
+	if got := string(emph.FirstChild().Literal()); emph.Type != mdast.Emph || got != "c" {
+		t.Errorf("last child of the paragraph is %s with %q, want Emph with \"c\"", emph.Type, got)
+	}
+	if !para.IsContainer() || !emph.IsContainer() || hr.IsContainer() || emph.FirstChild().IsContainer() {
+		t.Errorf("IsContainer is wrong")
+	}
+	pos := para.SourcePos()
+	if pos.Line() != 3 || pos.Char() != 1 || pos.EndLine() != 3 || pos.EndChar() != 5 {
+		t.Errorf("paragraph position is %s, want 3:1-3:5", pos)
+	}
+	if got := mdast.NewNode(mdast.Text, nil).SourcePos(); got != (mdast.SourceRange{}) {
+		t.Errorf("unknown position is %s, want the zero SourceRange", got)
+	}
+}
+
+func TestListDataAccessors(t *testing.T) {
+	doc := mustParse(t, "  3) a\n  4) b\n\n- c\n\n  d\n")
+	ordered := doc.FirstChild().ListData()
+	if ordered.ListType() != mdast.OrderedList || !ordered.Tight() || ordered.Start() != 3 ||
+		ordered.Delimiter() != ')' || ordered.Padding() != 3 || ordered.MarkerOffset() != 2 {
+		t.Errorf("ordered list data is %+v", ordered)
+	}
+	if got := doc.FirstChild().FirstChild().ListData(); got != ordered {
+		t.Errorf("item list data is %+v, want %+v", got, ordered)
+	}
+	bullet := doc.LastChild().ListData()
+	if bullet.ListType() != mdast.BulletList || bullet.Tight() || bullet.BulletChar() != '-' ||
+		bullet.Padding() != 2 || bullet.MarkerOffset() != 0 {
+		t.Errorf("bullet list data is %+v", bullet)
+	}
+	if got := doc.LastChild().FirstChild().FirstChild().ListData(); got != (mdast.ListData{}) {
+		t.Errorf("paragraph list data is %+v, want the zero ListData", got)
+	}
+	if got := mdast.OrderedList.String(); got != "OrderedList" {
+		t.Errorf("list type is %q, want \"OrderedList\"", got)
+	}
+}
+
+func TestLeafAccessors(t *testing.T) {
+	doc := mustParse(t, "  ~~~~ go run\n  x\n  ~~~~\n\n    y\n\n<!-- z -->\n\n[a](/u \"t\") ![b](/i.png)\n")
+	fenced := doc.FirstChild()
+	if !fenced.IsFenced() || fenced.FenceChar() != '~' || fenced.FenceLength() != 4 || fenced.FenceOffset() != 2 {
+		t.Errorf("fence is %t %q %d %d, want true '~' 4 2",
+			fenced.IsFenced(), fenced.FenceChar(), fenced.FenceLength(), fenced.FenceOffset())
+	}
+	if got := string(fenced.Info()); got != "go run" {
+		t.Errorf("info string is %q, want \"go run\"", got)
+	}
+	if got := string(fenced.Literal()); got != "x\n" {
+		t.Errorf("fenced code is %q, want \"x\\n\"", got)
+	}
+	indented := fenced.Next()
+	if indented.IsFenced() || indented.Info() != nil || string(indented.Literal()) != "y\n" {
+		t.Errorf("indented code is %t %q %q", indented.IsFenced(), indented.Info(), indented.Literal())
+	}
+	html := indented.Next()
+	if html.HtmlBlockType() != 2 || string(html.Literal()) != "<!-- z -->" {
+		t.Errorf("HTML block is type %d with %q", html.HtmlBlockType(), html.Literal())
+	}
+	link := html.Next().FirstChild()
+	if string(link.Destination()) != "/u" || string(link.Title()) != "t" {
+		t.Errorf("link is %q %q, want \"/u\" \"t\"", link.Destination(), link.Title())
+	}
+	image := html.Next().LastChild()
+	if image.Type != mdast.Image || string(image.Destination()) != "/i.png" || image.Title() != nil {
+		t.Errorf("image is %s %q %q", image.Type, image.Destination(), image.Title())
+	}
+}
+
+func TestTypedViews(t *testing.T) {
+	doc := mustParse(t, "```go\nx\n```\n\n[a](/u \"t\") ![b](/i.png)\n")
+	code, ok := doc.FirstChild().CodeBlockData()
+	if !ok || !code.IsFenced() || code.FenceChar() != '`' || code.FenceLength() != 3 ||
+		code.FenceOffset() != 0 || string(code.Info()) != "go" || string(code.Literal()) != "x\n" {
+		t.Errorf("code block data is %t %+v", ok, code)
+	}
+	para := doc.LastChild()
+	link, ok := para.FirstChild().LinkData()
+	if !ok || string(link.Destination()) != "/u" || string(link.Title()) != "t" {
+		t.Errorf("link data is %t %q %q", ok, link.Destination(), link.Title())
+	}
+	image, ok := para.LastChild().LinkData()
+	if !ok || string(image.Destination()) != "/i.png" || image.Title() != nil {
+		t.Errorf("image data is %t %q %q", ok, image.Destination(), image.Title())
+	}
+	if _, ok := para.LinkData(); ok {
+		t.Errorf("a paragraph has link data")
+	}
+	if _, ok := para.FirstChild().CodeBlockData(); ok {
+		t.Errorf("a link has code block data")
+	}
+}
@@ -0,0 +1,56 @@
+package mdast
+
+// nodeSlab hands out the Nodes and SourceRanges of a document from chunks
+// allocated in bulk, so that parsing makes one allocation per chunk instead
+// of one per node. Chunks start small, so that short documents stay cheap,
+// and double in size up to maxSlabChunk.
+//
+// A chunk is only garbage collected once none of its nodes are referenced,
+// so holding on to one node of a discarded document keeps its neighbours
+// alive as well.
+type nodeSlab struct {
+	nodes      []Node
+	ranges     []SourceRange
+	nodeChunk  int // size of the last chunk of nodes
+	rangeChunk int // size of the last chunk of ranges
+}
+
+const (
+	minSlabChunk = 16
+	maxSlabChunk = 256
+)
+
+func nextChunkSize(last int) int {
+	if last < minSlabChunk {
+		return minSlabChunk
+	}
+	if last*2 > maxSlabChunk {
+		return maxSlabChunk
+	}
+	return last * 2
+}
+
+// node returns a node that is set up like the one NewNode returns.
+func (s *nodeSlab) node(typ NodeType, src *SourceRange) *Node {
+	if len(s.nodes) == 0 {
+		s.nodeChunk = nextChunkSize(s.nodeChunk)
+		s.nodes = make([]Node, s.nodeChunk)
+	}
+	n := &s.nodes[0]
+	s.nodes = s.nodes[1:]
+	n.Type = typ
+	n.sourcePos = src
+	n.open = true
+	return n
+}
+
+// sourceRange returns a zeroed SourceRange.
+func (s *nodeSlab) sourceRange() *SourceRange {
+	if len(s.ranges) == 0 {
+		s.rangeChunk = nextChunkSize(s.rangeChunk)
+		s.ranges = make([]SourceRange, s.rangeChunk)
+	}
+	r := &s.ranges[0]
+	s.ranges = s.ranges[1:]
+	return r
+}
@@ -1,18 +1,42 @@
-package main
+// Package mdast parses CommonMark documents into an abstract syntax tree and
+// renders the tree to HTML. It is a port of the commonmark.js reference
+// implementation.
+package mdast
 
 import (
 	"bytes"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"regexp"
+	"sort"
+	"strconv"
 )
 
+// reHtmlBlockOpen and reHtmlBlockClose hold the start and end conditions of
+// the seven kinds of HTML blocks, indexed by htmlBlockType. Blocks of type 6
+// and 7 end at a blank line instead.
 var (
-	reATXHeaderMarker = regexp.MustCompile("^#{1,6}(?: +|$)")
-	reHrule           = regexp.MustCompile("^(?:(?:\\* *){3,}|(?:_ *){3,}|(?:- *){3,}) *$")
+	reHtmlBlockOpen = []*regexp.Regexp{
+		nil, // no type 0
+		regexp.MustCompile(`(?i)^<(?:script|pre|style|textarea)(?:\s|>|$)`),
+		regexp.MustCompile(`^<!--`),
+		regexp.MustCompile(`^<[?]`),
+		regexp.MustCompile(`^<![A-Za-z]`),
+		regexp.MustCompile(`^<!\[CDATA\[`),
+		regexp.MustCompile(`(?i)^<[/]?(?:address|article|aside|base|basefont|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[123456]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|search|section|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul)(?:\s|[/]?[>]|$)`),
+		regexp.MustCompile(`(?i)^(?:` + openTag + `|` + closeTag + `)\s*$`),
+	}
+	reHtmlBlockClose = []*regexp.Regexp{
+		nil, // no type 0
+		regexp.MustCompile(`(?i)</(?:script|pre|style|textarea)>`),
+		regexp.MustCompile(`-->`),
+		regexp.MustCompile(`\?>`),
+		regexp.MustCompile(`>`),
+		regexp.MustCompile(`\]\]>`),
+	}
 )
 
+const codeIndent = 4
+
 type NodeType int
 
 const (
@@ -23,11 +47,17 @@ const (
 	Paragraph
 	Header
 	HorizontalRule
+	CodeBlock
+	HtmlBlock
 	Emph
 	Strong
 	Link
 	Image
+	Code
+	Softbreak
+	Hardbreak
 	Text
+	HtmlInline
 )
 
 var nodeTypeNames = []string{
@@ -38,31 +68,50 @@ var nodeTypeNames = []string{
 	Paragraph:      "Paragraph",
 	Header:         "Header",
 	HorizontalRule: "HorizontalRule",
+	CodeBlock:      "CodeBlock",
+	HtmlBlock:      "HtmlBlock",
 	Emph:           "Emph",
 	Strong:         "Strong",
 	Link:           "Link",
 	Image:          "Image",
+	Code:           "Code",
+	Softbreak:      "Softbreak",
+	Hardbreak:      "Hardbreak",
 	Text:           "Text",
+	HtmlInline:     "HtmlInline",
 }
 
 func (t NodeType) String() string {
-	return nodeTypeNames[t]
+	if int(t) < len(nodeTypeNames) {
+		return nodeTypeNames[t]
+	}
+	if custom, ok := customNodeType(t); ok {
+		return custom.name
+	}
+	return fmt.Sprintf("NodeType(%d)", int(t))
 }
 
+// blockHandlers are the handlers every parser starts with.
 var blockHandlers = map[NodeType]BlockHandler{
 	Document:       &DocumentBlockHandler{},
 	Header:         &HeaderBlockHandler{},
 	HorizontalRule: &HorizontalRuleBlockHandler{},
+	CodeBlock:      &CodeBlockHandler{},
+	HtmlBlock:      &HtmlBlockHandler{},
 	BlockQuote:     &BlockQuoteBlockHandler{},
+	List:           &ListBlockHandler{},
+	Item:           &ItemBlockHandler{},
 	Paragraph:      &ParagraphBlockHandler{},
 }
 
+// ContinueStatus is what BlockHandler.Continue returns about an open block
+// and the current line.
 type ContinueStatus int
 
 const (
-	Matched = iota
-	NotMatched
-	Completed
+	Matched    ContinueStatus = iota // the line continues the block
+	NotMatched                       // the line doesn't continue the block
+	Completed                        // the line ended the block, and has been consumed
 )
 
 type BlockHandler interface {
@@ -128,6 +177,89 @@ func (h *HorizontalRuleBlockHandler) AcceptsLines() bool {
 	return false
 }
 
+type CodeBlockHandler struct {
+}
+
+func (h *CodeBlockHandler) Continue(p *Parser, container *Node) ContinueStatus {
+	ln := p.currentLine
+	if container.isFenced {
+		if p.indent <= 3 && peek(ln, p.nextNonspace) == container.fenceChar &&
+			uint32(scanUnderline(ln[p.nextNonspace:], container.fenceChar)) >= container.fenceLength {
+			// closing fence - we're at end of line, so we can return
+			p.lastLineLength = uint32(len(ln))
+			p.finalize(container, p.lineNumber)
+			return Completed
+		}
+		// skip optional spaces of fence offset
+		for i := container.fenceOffset; i > 0 && peek(ln, p.offset) == ' '; i -= 1 {
+			p.advanceOffset(1, false)
+		}
+	} else {
+		if p.indent >= codeIndent {
+			p.advanceOffset(codeIndent, true)
+		} else if p.blank {
+			p.advanceNextNonspace()
+		} else {
+			return NotMatched
+		}
+	}
+	return Matched
+}
+
+func (h *CodeBlockHandler) Finalize(p *Parser, block *Node) {
+	if block.isFenced {
+		// first line becomes info string
+		firstLine := block.content
+		rest := []byte{}
+		if newlinePos := bytes.IndexByte(block.content, '\n'); newlinePos >= 0 {
+			firstLine = block.content[:newlinePos]
+			rest = block.content[newlinePos+1:]
+		}
+		block.info = unescapeString(bytes.TrimSpace(firstLine))
+		block.literal = rest
+	} else {
+		// indented: trailing blank lines are not part of the block
+		block.literal = block.content
+		if end := trailingBlankLines(block.content); end < len(block.content) {
+			block.literal = block.content[:end+1] // keep one newline
+		}
+	}
+	block.content = nil // allow raw string to be garbage collected
+	block.lineOffsets = nil
+}
+
+func (h *CodeBlockHandler) CanContain(t NodeType) bool {
+	return false
+}
+
+func (h *CodeBlockHandler) AcceptsLines() bool {
+	return true
+}
+
+type HtmlBlockHandler struct {
+}
+
+func (h *HtmlBlockHandler) Continue(p *Parser, container *Node) ContinueStatus {
+	if p.blank && (container.htmlBlockType == 6 || container.htmlBlockType == 7) {
+		return NotMatched
+	}
+	return Matched
+}
+
+func (h *HtmlBlockHandler) Finalize(p *Parser, block *Node) {
+	block.literal = block.content[:trailingBlankLines(block.content)]
+	block.content = nil // allow raw string to be garbage collected
+	block.lineOffsets = nil
+}
+
+func (h *HtmlBlockHandler) CanContain(t NodeType) bool {
+	return false
+}
+
+func (h *HtmlBlockHandler) AcceptsLines() bool {
+	return true
+}
+
 type BlockQuoteBlockHandler struct {
 }
 
@@ -136,8 +268,8 @@ func (h *BlockQuoteBlockHandler) Continue(p *Parser, container *Node) ContinueSt
 	if !p.indented && peek(ln, p.nextNonspace) == '>' {
 		p.advanceNextNonspace()
 		p.advanceOffset(1, false)
-		if peek(ln, p.offset) == ' ' {
-			p.offset += 1
+		if isSpaceOrTab(peek(ln, p.offset)) {
+			p.advanceOffset(1, true)
 		}
 	} else {
 		return NotMatched
@@ -156,6 +288,72 @@ func (h *BlockQuoteBlockHandler) AcceptsLines() bool {
 	return false
 }
 
+type ListBlockHandler struct {
+}
+
+func (h *ListBlockHandler) Continue(p *Parser, container *Node) ContinueStatus {
+	return Matched
+}
+
+func (h *ListBlockHandler) Finalize(p *Parser, block *Node) {
+	item := block.firstChild
+	for item != nil {
+		// check for non-final list item ending with blank line:
+		if endsWithBlankLine(item) && item.next != nil {
+			block.listData.tight = false
+			break
+		}
+		// recurse into children of list item, to see if there are
+		// spaces between any of them:
+		subitem := item.firstChild
+		for subitem != nil {
+			if endsWithBlankLine(subitem) && (item.next != nil || subitem.next != nil) {
+				block.listData.tight = false
+				break
+			}
+			subitem = subitem.next
+		}
+		item = item.next
+	}
+}
+
+func (h *ListBlockHandler) CanContain(t NodeType) bool {
+	return t == Item
+}
+
+func (h *ListBlockHandler) AcceptsLines() bool {
+	return false
+}
+
+type ItemBlockHandler struct {
+}
+
+func (h *ItemBlockHandler) Continue(p *Parser, container *Node) ContinueStatus {
+	if p.blank {
+		if container.firstChild == nil {
+			// blank line after empty list item
+			return NotMatched
+		}
+		p.advanceNextNonspace()
+	} else if p.indent >= container.listData.markerOffset+container.listData.padding {
+		p.advanceOffset(container.listData.markerOffset+container.listData.padding, true)
+	} else {
+		return NotMatched
+	}
+	return Matched
+}
+
+func (h *ItemBlockHandler) Finalize(p *Parser, block *Node) {
+}
+
+func (h *ItemBlockHandler) CanContain(t NodeType) bool {
+	return t != Item
+}
+
+func (h *ItemBlockHandler) AcceptsLines() bool {
+	return false
+}
+
 type ParagraphBlockHandler struct {
 }
 
@@ -168,18 +366,12 @@ func (h *ParagraphBlockHandler) Continue(p *Parser, container *Node) ContinueSta
 }
 
 func (h *ParagraphBlockHandler) Finalize(p *Parser, block *Node) {
-	/*
-		TODO:
-			hasReferenceDefs := false
-			for peek(block.content, 0) == '[' &&
-				(pos := p.inlineParser.parseReference(block.content, p.refmap); pos != 0) {
-				block.content = block.content[pos:]
-				hasReferenceDefs = true
-			}
-			if hasReferenceDefs && isBlank(block.content) {
-				block.unlink()
-			}
-	*/
+	p.parseReferenceDefs(block)
+	// a paragraph is only ever blank if it consisted of definitions alone,
+	// possibly stripped earlier by the setext header check
+	if isBlank(block.content) {
+		block.Unlink()
+	}
 }
 
 func (h *ParagraphBlockHandler) CanContain(t NodeType) bool {
@@ -206,41 +398,421 @@ func NewSourceRange() *SourceRange {
 	}
 }
 
+// String formats the range as "line:char-endLine:endChar", the way it
+// appears in the data-sourcepos attribute.
+func (r SourceRange) String() string {
+	return fmt.Sprintf("%d:%d-%d:%d", r.line, r.char, r.endLine, r.endChar)
+}
+
+// lineOffset maps a line of a block's content back to the source document.
+type lineOffset struct {
+	offset  int    // offset of the line in the block's content, dropped bytes included
+	line    uint32 // line number in the source document
+	char    uint32 // column of the line's first byte in the source
+	virtual int    // number of spaces standing in for a partially consumed tab
+}
+
+// Line returns the line the range starts on, counting from 1.
+func (r SourceRange) Line() int {
+	return int(r.line)
+}
+
+// Char returns the column the range starts at, counting from 1.
+func (r SourceRange) Char() int {
+	return int(r.char)
+}
+
+// EndLine returns the line the range ends on.
+func (r SourceRange) EndLine() int {
+	return int(r.endLine)
+}
+
+// EndChar returns the column of the last character in the range.
+func (r SourceRange) EndChar() int {
+	return int(r.endChar)
+}
+
+type ListType int
+
+const (
+	BulletList ListType = iota
+	OrderedList
+)
+
+func (t ListType) String() string {
+	if t == OrderedList {
+		return "OrderedList"
+	}
+	return "BulletList"
+}
+
+type ListData struct {
+	listType     ListType
+	tight        bool   // true if the list items are not separated by blank lines
+	bulletChar   byte   // '*', '+' or '-' for bullet lists
+	start        uint32 // start number for ordered lists
+	delimiter    byte   // '.' or ')' for ordered lists
+	padding      uint32 // marker width plus spaces up to the item's content
+	markerOffset uint32 // indentation of the marker itself
+}
+
+// ListType returns whether the list is a bullet or an ordered one.
+func (d ListData) ListType() ListType {
+	return d.listType
+}
+
+// Tight returns true if the list items are not separated by blank lines, in
+// which case the paragraphs in them are rendered without <p> tags.
+func (d ListData) Tight() bool {
+	return d.tight
+}
+
+// BulletChar returns the marker of a bullet list: '*', '+' or '-'.
+func (d ListData) BulletChar() byte {
+	return d.bulletChar
+}
+
+// Start returns the number of the first item of an ordered list.
+func (d ListData) Start() int {
+	return int(d.start)
+}
+
+// Delimiter returns the character after the number of an ordered list's
+// marker: '.' or ')'.
+func (d ListData) Delimiter() byte {
+	return d.delimiter
+}
+
+// Padding returns the width of the list marker plus the spaces that follow
+// it, which is the indentation needed for an item's continuation lines.
+func (d ListData) Padding() int {
+	return int(d.padding)
+}
+
+// MarkerOffset returns the indentation of the list marker.
+func (d ListData) MarkerOffset() int {
+	return int(d.markerOffset)
+}
+
+// LinkData holds the properties of a Link or Image node.
+type LinkData struct {
+	destination []byte
+	title       []byte
+}
+
+// Destination returns the URL of the link or image. The returned slice must
+// not be modified.
+func (d LinkData) Destination() []byte {
+	return d.destination
+}
+
+// Title returns the title of the link or image, or nil if it has none. The
+// returned slice must not be modified.
+func (d LinkData) Title() []byte {
+	return d.title
+}
+
+// CodeBlockData holds the properties of a CodeBlock node.
+type CodeBlockData struct {
+	literal     []byte
+	info        []byte
+	isFenced    bool
+	fenceChar   byte
+	fenceLength uint32
+	fenceOffset uint32
+}
+
+// Literal returns the code. The returned slice must not be modified.
+func (d CodeBlockData) Literal() []byte {
+	return d.literal
+}
+
+// Info returns the info string of a fenced block, or nil for an indented
+// one. The returned slice must not be modified.
+func (d CodeBlockData) Info() []byte {
+	return d.info
+}
+
+// IsFenced returns true for a fenced block and false for an indented one.
+func (d CodeBlockData) IsFenced() bool {
+	return d.isFenced
+}
+
+// FenceChar returns the character of a fenced block's fence, '`' or '~'.
+func (d CodeBlockData) FenceChar() byte {
+	return d.fenceChar
+}
+
+// FenceLength returns the length of a fenced block's opening fence.
+func (d CodeBlockData) FenceLength() int {
+	return int(d.fenceLength)
+}
+
+// FenceOffset returns the indentation of a fenced block's opening fence.
+func (d CodeBlockData) FenceOffset() int {
+	return int(d.fenceOffset)
+}
+
 type Node struct {
-	Type       NodeType
-	parent     *Node
-	firstChild *Node
-	lastChild  *Node
-	prev       *Node // prev sibling
-	next       *Node // next sibling
-	sourcePos  *SourceRange
-	content    []byte
-	level      uint32
-	open       bool
-	//isFenced      bool
+	Type          NodeType
+	parent        *Node
+	firstChild    *Node
+	lastChild     *Node
+	prev          *Node // prev sibling
+	next          *Node // next sibling
+	sourcePos     *SourceRange
+	content       []byte
+	level         uint32
+	open          bool
+	isFenced      bool   // CodeBlock: fenced or indented
+	fenceChar     byte   // CodeBlock: '`' or '~'
+	fenceLength   uint32 // CodeBlock: number of fence chars in the opening fence
+	fenceOffset   uint32 // CodeBlock: indentation of the opening fence
+	info          []byte // CodeBlock: info string of a fenced block
+	htmlBlockType int    // HtmlBlock: which of the 7 kinds of HTML block this is
+	destination   []byte // Link, Image: link destination
+	title         []byte // Link, Image: link title
 	lastLineBlank bool
 	literal       []byte
+	listData      *ListData    // for List and Item only
+	lineOffsets   []lineOffset // where the content lines came from
+	dropped       int          // bytes dropped from the start of content
+	attrs         []Attr       // HTML attributes added by the user
 }
 
 func NewNode(typ NodeType, src *SourceRange) *Node {
+	level := uint32(0)
+	if typ == Header {
+		level = 1
+	}
 	return &Node{
-		Type:       typ,
-		parent:     nil,
-		firstChild: nil,
-		lastChild:  nil,
-		prev:       nil,
-		next:       nil,
-		sourcePos:  src,
-		content:    nil,
-		level:      0,
-		open:       true,
-		//isFenced:      false,
+		Type:          typ,
+		parent:        nil,
+		firstChild:    nil,
+		lastChild:     nil,
+		prev:          nil,
+		next:          nil,
+		sourcePos:     src,
+		content:       nil,
+		level:         level,
+		open:          true,
+		isFenced:      false,
+		fenceChar:     0,
+		fenceLength:   0,
+		fenceOffset:   0,
+		info:          nil,
+		htmlBlockType: 0,
+		destination:   nil,
+		title:         nil,
 		lastLineBlank: false,
 		literal:       nil,
+		listData:      nil,
+		lineOffsets:   nil,
+		dropped:       0,
+		attrs:         nil,
+	}
+}
+
+// Parent returns the node's parent, or nil for the root.
+func (n *Node) Parent() *Node {
+	return n.parent
+}
+
+// FirstChild returns the node's first child, or nil if it has none.
+func (n *Node) FirstChild() *Node {
+	return n.firstChild
+}
+
+// LastChild returns the node's last child, or nil if it has none.
+func (n *Node) LastChild() *Node {
+	return n.lastChild
+}
+
+// Prev returns the node's previous sibling, or nil if it is the first child.
+func (n *Node) Prev() *Node {
+	return n.prev
+}
+
+// Next returns the node's next sibling, or nil if it is the last child.
+func (n *Node) Next() *Node {
+	return n.next
+}
+
+// Literal returns the text of a Text, Code, CodeBlock, HtmlBlock or
+// HtmlInline node. The returned slice must not be modified.
+func (n *Node) Literal() []byte {
+	return n.literal
+}
+
+// SourcePos returns the extent of the node in the source document. It is the
+// zero SourceRange if the position is unknown.
+func (n *Node) SourcePos() SourceRange {
+	if n.sourcePos == nil {
+		return SourceRange{}
+	}
+	return *n.sourcePos
+}
+
+// Level returns the level of a Header node, 1 to 6.
+func (n *Node) Level() int {
+	return int(n.level)
+}
+
+// ListData returns the list properties of a List or Item node, or the zero
+// ListData for other nodes.
+func (n *Node) ListData() ListData {
+	if n.listData == nil {
+		return ListData{}
+	}
+	return *n.listData
+}
+
+// LinkData returns the properties of a Link or Image node, and false for the
+// other nodes.
+func (n *Node) LinkData() (LinkData, bool) {
+	if n.Type != Link && n.Type != Image {
+		return LinkData{}, false
 	}
+	return LinkData{destination: n.destination, title: n.title}, true
+}
+
+// CodeBlockData returns the properties of a CodeBlock node, and false for the
+// other nodes.
+func (n *Node) CodeBlockData() (CodeBlockData, bool) {
+	if n.Type != CodeBlock {
+		return CodeBlockData{}, false
+	}
+	return CodeBlockData{
+		literal:     n.literal,
+		info:        n.info,
+		isFenced:    n.isFenced,
+		fenceChar:   n.fenceChar,
+		fenceLength: n.fenceLength,
+		fenceOffset: n.fenceOffset,
+	}, true
+}
+
+// Destination returns the URL of a Link or Image node. The returned slice
+// must not be modified.
+func (n *Node) Destination() []byte {
+	return n.destination
+}
+
+// Title returns the title of a Link or Image node. The returned slice must
+// not be modified.
+func (n *Node) Title() []byte {
+	return n.title
+}
+
+// Info returns the info string of a fenced CodeBlock node. The returned
+// slice must not be modified.
+func (n *Node) Info() []byte {
+	return n.info
+}
+
+// SetLiteral sets the text of the node. It is meant for the handlers of
+// custom blocks, which usually turn the content of the block into its literal
+// text when it is finalized.
+func (n *Node) SetLiteral(literal []byte) {
+	n.literal = literal
+}
+
+// SetInfo sets the info string of the node.
+func (n *Node) SetInfo(info []byte) {
+	n.info = info
+}
+
+// SetLevel sets the level of a Header node. It panics unless level is
+// between 1 and 6.
+func (n *Node) SetLevel(level int) {
+	if level < 1 || level > 6 {
+		panic(fmt.Sprintf("mdast: header level %d out of range", level))
+	}
+	n.level = uint32(level)
+}
+
+// SetDestination sets the URL of a Link or Image node.
+func (n *Node) SetDestination(destination []byte) {
+	n.destination = destination
+}
+
+// SetTitle sets the title of a Link or Image node.
+func (n *Node) SetTitle(title []byte) {
+	n.title = title
 }
 
-func (n *Node) unlink() {
+// Attrs returns the HTML attributes set on the node with SetAttr. The
+// returned slice must not be modified.
+func (n *Node) Attrs() []Attr {
+	return n.attrs
+}
+
+// SetAttr sets an HTML attribute of the node, replacing the value of the
+// attribute with the same key if there is one. The renderer adds the
+// attributes to the opening tag of the node, after the ones it makes itself.
+// They are ignored for the nodes that have no tags of their own: Document,
+// Text, Softbreak, Hardbreak, HtmlInline, HtmlBlock and the paragraphs of
+// tight lists.
+func (n *Node) SetAttr(key string, value []byte) {
+	for i := range n.attrs {
+		if n.attrs[i].Key == key {
+			n.attrs[i].Value = value
+			return
+		}
+	}
+	n.attrs = append(n.attrs, Attr{key, value})
+}
+
+// Content returns the lines added to a block that accepts lines, while it is
+// being parsed. The returned slice must not be modified.
+func (n *Node) Content() []byte {
+	return n.content
+}
+
+// IsFenced returns true for a fenced CodeBlock and false for an indented one.
+func (n *Node) IsFenced() bool {
+	return n.isFenced
+}
+
+// FenceChar returns the character of a fenced CodeBlock's fence, '`' or '~'.
+func (n *Node) FenceChar() byte {
+	return n.fenceChar
+}
+
+// FenceLength returns the length of a fenced CodeBlock's opening fence.
+func (n *Node) FenceLength() int {
+	return int(n.fenceLength)
+}
+
+// FenceOffset returns the indentation of a fenced CodeBlock's opening fence.
+func (n *Node) FenceOffset() int {
+	return int(n.fenceOffset)
+}
+
+// HtmlBlockType returns which of the seven kinds of HTML block, numbered as
+// in the CommonMark spec, an HtmlBlock node is.
+func (n *Node) HtmlBlockType() int {
+	return n.htmlBlockType
+}
+
+// IsContainer returns true if nodes of this type can have children.
+func (n *Node) IsContainer() bool {
+	return n.isContainer()
+}
+
+// checkInsert panics if putting node in the tree next to or below n would
+// make the tree cyclic, that is, if node is n or one of its ancestors.
+func (n *Node) checkInsert(node *Node) {
+	for a := n; a != nil; a = a.parent {
+		if a == node {
+			panic("mdast: can't insert a node into its own subtree")
+		}
+	}
+}
+
+// Unlink detaches the node, together with its subtree, from its parent and
+// siblings. The node becomes the root of a tree of its own.
+func (n *Node) Unlink() {
 	if n.prev != nil {
 		n.prev.next = n.next
 	} else if n.parent != nil {
@@ -256,8 +828,17 @@ func (n *Node) unlink() {
 	n.prev = nil
 }
 
+// AppendChild adds child as the last child of n. The child is unlinked from
+// its previous position first.
+func (n *Node) AppendChild(child *Node) {
+	n.checkInsert(child)
+	n.appendChild(child)
+}
+
+// appendChild is AppendChild without the check for cycles, for the parser,
+// which only moves nodes that can't be ancestors of n.
 func (n *Node) appendChild(child *Node) {
-	child.unlink()
+	child.Unlink()
 	child.parent = n
 	if n.lastChild != nil {
 		n.lastChild.next = child
@@ -269,6 +850,114 @@ func (n *Node) appendChild(child *Node) {
 	}
 }
 
+// PrependChild adds child as the first child of n. The child is unlinked from
+// its previous position first.
+func (n *Node) PrependChild(child *Node) {
+	n.checkInsert(child)
+	child.Unlink()
+	child.parent = n
+	if n.firstChild != nil {
+		n.firstChild.prev = child
+		child.next = n.firstChild
+		n.firstChild = child
+	} else {
+		n.firstChild = child
+		n.lastChild = child
+	}
+}
+
+// InsertAfter adds sibling to the tree as the next sibling of n. The sibling
+// is unlinked from its previous position first.
+func (n *Node) InsertAfter(sibling *Node) {
+	n.checkInsert(sibling)
+	n.insertAfter(sibling)
+}
+
+// insertAfter is InsertAfter without the check for cycles.
+func (n *Node) insertAfter(sibling *Node) {
+	sibling.Unlink()
+	sibling.next = n.next
+	if sibling.next != nil {
+		sibling.next.prev = sibling
+	}
+	sibling.prev = n
+	n.next = sibling
+	sibling.parent = n.parent
+	if sibling.next == nil && sibling.parent != nil {
+		sibling.parent.lastChild = sibling
+	}
+}
+
+// InsertBefore adds sibling to the tree as the previous sibling of n. The
+// sibling is unlinked from its previous position first.
+func (n *Node) InsertBefore(sibling *Node) {
+	n.checkInsert(sibling)
+	sibling.Unlink()
+	sibling.prev = n.prev
+	if sibling.prev != nil {
+		sibling.prev.next = sibling
+	}
+	sibling.next = n
+	n.prev = sibling
+	sibling.parent = n.parent
+	if sibling.prev == nil && sibling.parent != nil {
+		sibling.parent.firstChild = sibling
+	}
+}
+
+// ReplaceWith puts node in the tree in place of n, which is unlinked. The
+// node is unlinked from its previous position first, so it may come from
+// n's own subtree.
+func (n *Node) ReplaceWith(node *Node) {
+	if node == n {
+		return
+	}
+	n.InsertAfter(node)
+	n.Unlink()
+}
+
+// Clone returns a deep copy of the node and its subtree. The copy is not
+// linked to the original tree. The byte slices holding the literal text,
+// destination, title and info string are shared with the original, since
+// they are never modified in place.
+func (n *Node) Clone() *Node {
+	clone := *n
+	clone.parent = nil
+	clone.firstChild = nil
+	clone.lastChild = nil
+	clone.prev = nil
+	clone.next = nil
+	if n.sourcePos != nil {
+		pos := *n.sourcePos
+		clone.sourcePos = &pos
+	}
+	if n.listData != nil {
+		data := *n.listData
+		clone.listData = &data
+	}
+	clone.lineOffsets = append([]lineOffset(nil), n.lineOffsets...)
+	clone.attrs = append([]Attr(nil), n.attrs...)
+	for child := n.firstChild; child != nil; child = child.next {
+		clone.appendChild(child.Clone())
+	}
+	return &clone
+}
+
+// dropContent removes the first count bytes of the node's content. The line
+// offsets keep counting from the start of the content before any drops, so
+// only the lines dropped entirely are removed from them.
+func (n *Node) dropContent(count int) {
+	n.content = n.content[count:]
+	n.dropped += count
+	// the last line that starts at or before the new start of the content
+	i := sort.Search(len(n.lineOffsets), func(i int) bool {
+		return n.lineOffsets[i].offset > n.dropped
+	}) - 1
+	if i > 0 {
+		n.lineOffsets = n.lineOffsets[i:]
+	}
+}
+
 func (n *Node) isContainer() bool {
 	switch n.Type {
 	case Document:
@@ -292,63 +981,31 @@ func (n *Node) isContainer() bool {
 	case Image:
 		return true
 	default:
-		return false
+		custom, ok := customNodeType(n.Type)
+		return ok && custom.container
 	}
-	return false
 }
 
-type NodeWalker struct {
-	current  *Node
-	root     *Node
-	entering bool
-}
-
-func NewNodeWalker(root *Node) *NodeWalker {
-	return &NodeWalker{
-		current:  root,
-		root:     nil,
-		entering: true,
-	}
-}
-
-func (nw *NodeWalker) next() (*Node, bool) {
-	if nw.current == nil {
-		return nil, false
-	}
-	if nw.root == nil {
-		nw.root = nw.current
-		return nw.current, nw.entering
-	}
-	if nw.entering && nw.current.isContainer() {
-		if nw.current.firstChild != nil {
-			nw.current = nw.current.firstChild
-			nw.entering = true
-		} else {
-			nw.entering = false
+// endsWithBlankLine returns true if block ends with a blank line, descending
+// if needed into lists and sublists.
+func endsWithBlankLine(block *Node) bool {
+	for block != nil {
+		if block.lastLineBlank {
+			return true
 		}
-	} else if nw.current.next == nil {
-		nw.current = nw.current.parent
-		nw.entering = false
-	} else {
-		nw.current = nw.current.next
-		nw.entering = true
-	}
-	if nw.current == nw.root {
-		return nil, false
+		if block.Type != List && block.Type != Item {
+			break
+		}
+		block = block.lastChild
 	}
-	return nw.current, nw.entering
-}
-
-func (nw *NodeWalker) resumeAt(node *Node, entering bool) {
-	nw.current = node
-	nw.entering = entering
+	return false
 }
 
 type Parser struct {
-	doc    *Node
-	tip    *Node // = doc
-	oldTip *Node
-	//refmap
+	doc                  *Node
+	tip                  *Node // = doc
+	oldTip               *Node
+	refmap               map[string]*reference
 	lineNumber           uint32
 	lastLineLength       uint32
 	offset               uint32
@@ -357,67 +1014,300 @@ type Parser struct {
 	nextNonspaceColumn   uint32
 	lastMatchedContainer *Node // = doc
 	currentLine          []byte
-	lines                [][]byte // input document.split(newlines)
+	partiallyConsumedTab bool
+	input                []byte // the document being parsed
+	lineStart            int    // offset of currentLine in input
+	aliased              *Node  // block whose content is a slice of input...
+	aliasedEnd           int    // ...ending here, so that it can be extended
+	slab                 nodeSlab
 	indent               uint32
 	indented             bool
 	blank                bool
 	allClosed            bool
 	inlineParser         *InlineParser
+	options              Options
+	blockHandlers        map[NodeType]BlockHandler
+	blockTriggers        []blockTrigger
+}
+
+// RawHTMLMode selects what the parser does with raw HTML in the input.
+type RawHTMLMode int
+
+const (
+	RawHTMLPassThrough RawHTMLMode = iota // keep raw HTML, to be output verbatim
+	RawHTMLDrop                           // remove raw HTML from the document
+	RawHTMLEscape                         // turn raw HTML into literal text
+)
+
+// Options control the behaviour of the parser.
+type Options struct {
+	RawHTML RawHTMLMode
+	// Tracer, if not nil, is told about the progress of the parse.
+	Tracer Tracer
 }
 
-func NewParser() *Parser {
+func NewParser(opts Options) *Parser {
+	handlers := make(map[NodeType]BlockHandler, len(blockHandlers))
+	for t, handler := range blockHandlers {
+		handlers[t] = handler
+	}
+	p := &Parser{
+		refmap:        map[string]*reference{},
+		inlineParser:  newInlineParser(),
+		options:       opts,
+		blockHandlers: handlers,
+		blockTriggers: append([]blockTrigger(nil), blockTriggers...),
+	}
+	p.inlineParser.slab = &p.slab
+	p.Reset()
+	return p
+}
+
+// Reset discards the state left by the last parse, keeping the options and
+// the registered extensions. Parse resets the parser by itself; resetting a
+// parser before putting it in a sync.Pool lets the last document be garbage
+// collected.
+func (p *Parser) Reset() {
 	docNode := NewNode(Document, NewSourceRange())
-	return &Parser{
-		doc:                  docNode,
-		tip:                  docNode,
-		oldTip:               docNode,
-		lineNumber:           0,
-		lastLineLength:       0,
-		offset:               0,
-		column:               0,
-		lastMatchedContainer: docNode,
-		currentLine:          []byte{},
-		lines:                nil,
-		allClosed:            true,
-		inlineParser:         NewInlineParser(),
+	p.doc = docNode
+	p.tip = docNode
+	p.oldTip = docNode
+	for label := range p.refmap {
+		delete(p.refmap, label)
 	}
+	p.lineNumber = 0
+	p.lastLineLength = 0
+	p.offset = 0
+	p.column = 0
+	p.nextNonspace = 0
+	p.nextNonspaceColumn = 0
+	p.lastMatchedContainer = docNode
+	p.currentLine = nil
+	p.partiallyConsumedTab = false
+	p.input = nil
+	p.lineStart = 0
+	p.aliased = nil
+	p.aliasedEnd = 0
+	// drop the rest of the last document's chunks, so that they can be
+	// garbage collected with it
+	p.slab = nodeSlab{}
+	p.indent = 0
+	p.indented = false
+	p.blank = false
+	p.allClosed = true
+	p.inlineParser.reset()
 }
 
+// BlockStatus is what a BlockTrigger returns about the current line.
 type BlockStatus int
 
 const (
-	NoMatch = iota
-	ContainerMatch
-	LeafMatch
+	NoMatch        BlockStatus = iota // no block starts here
+	ContainerMatch                    // a container block started
+	LeafMatch                         // a leaf block started, holding the rest of the line
 )
 
-var blockTriggers = []func(p *Parser, container *Node) BlockStatus{
-	atxHeaderTrigger,
-	hruleTrigger,
-	blockquoteTrigger,
+// blockTriggers are the triggers every parser starts with, in the order they
+// are tried.
+var blockTriggers = []blockTrigger{
+	{ATXHeaderPriority, atxHeaderTrigger},
+	{FencedCodePriority, fencedCodeTrigger},
+	{HtmlBlockPriority, htmlBlockTrigger},
+	{SetextHeaderPriority, setextHeaderTrigger}, // must come before hrule, which also matches "---"
+	{HorizontalRulePriority, hruleTrigger},
+	{BlockQuotePriority, blockquoteTrigger},
+	{ListItemPriority, listItemTrigger},
+	{IndentedCodePriority, indentedCodeTrigger},
+}
+
+// scanATXHeaderMarker returns the length of the ATX header marker at the
+// start of line, including the spaces and tabs after it, and the level of the header.
+// The length is 0 if there is no marker.
+func scanATXHeaderMarker(line []byte) (length, level int) {
+	for level < len(line) && line[level] == '#' {
+		level += 1
+	}
+	if level == 0 || level > 6 {
+		return 0, 0
+	}
+	length = level
+	for length < len(line) && isSpaceOrTab(line[length]) {
+		length += 1
+	}
+	if length == level && length < len(line) {
+		return 0, 0 // the marker must be followed by a space, a tab or the end of line
+	}
+	return length, level
+}
+
+// trimATXClosing strips the optional closing sequence of #s, and the spaces
+// and tabs around it, from the content of an ATX header.
+func trimATXClosing(content []byte) []byte {
+	end := len(content)
+	for end > 0 && isSpaceOrTab(content[end-1]) {
+		end -= 1
+	}
+	hashes := end
+	for hashes > 0 && content[hashes-1] == '#' {
+		hashes -= 1
+	}
+	if hashes == end {
+		return content
+	}
+	start := hashes
+	for start > 0 && isSpaceOrTab(content[start-1]) {
+		start -= 1
+	}
+	if start == 0 {
+		return content[:0] // nothing but the closing sequence
+	}
+	if start == hashes {
+		return content // the #s are part of the last word
+	}
+	return content[:start]
 }
 
 func atxHeaderTrigger(p *Parser, container *Node) BlockStatus {
-	match := reATXHeaderMarker.Find(p.currentLine[p.nextNonspace:])
-	if !p.indented && match != nil {
+	length, level := scanATXHeaderMarker(p.currentLine[p.nextNonspace:])
+	if !p.indented && length > 0 {
 		p.advanceNextNonspace()
-		p.advanceOffset(uint32(len(match)), false)
+		p.advanceOffset(uint32(length), false)
 		p.closeUnmatchedBlocks()
 		container := p.addChild(Header, p.nextNonspace)
-		container.level = uint32(len(bytes.Trim(match, " \t\n\r"))) // number of #s
-		reLeft := regexp.MustCompile("^ *#+ *$")
-		reRight := regexp.MustCompile(" +#+ *$")
-		container.content = reRight.ReplaceAll(reLeft.ReplaceAll(p.currentLine[p.offset:], []byte{}), []byte{})
-		//parser.currentLine.slice(parser.offset).replace(/^ *#+ *$/, '').replace(/ +#+ *$/, '');
+		container.level = uint32(level)
+		container.content = trimATXClosing(p.currentLine[p.offset:])
+		container.lineOffsets = []lineOffset{{offset: 0, line: p.lineNumber, char: p.offset + 1}}
 		p.advanceOffset(uint32(len(p.currentLine))-p.offset, false)
 		return LeafMatch
 	}
 	return NoMatch
 }
 
+// scanCodeFence returns the length of the opening code fence at the start of
+// line, or 0 if there is none. A backtick fence can't have backticks in its
+// info string.
+func scanCodeFence(line []byte) uint32 {
+	fenceChar := peek(line, 0)
+	if fenceChar != '`' && fenceChar != '~' {
+		return 0
+	}
+	var n uint32
+	for peek(line, n) == fenceChar {
+		n += 1
+	}
+	if n < 3 {
+		return 0
+	}
+	if fenceChar == '`' && bytes.IndexByte(line[n:], '`') >= 0 {
+		return 0
+	}
+	return n
+}
+
+func fencedCodeTrigger(p *Parser, container *Node) BlockStatus {
+	fenceLength := scanCodeFence(p.currentLine[p.nextNonspace:])
+	if !p.indented && fenceLength > 0 {
+		p.closeUnmatchedBlocks()
+		container := p.addChild(CodeBlock, p.nextNonspace)
+		container.isFenced = true
+		container.fenceLength = fenceLength
+		container.fenceChar = p.currentLine[p.nextNonspace]
+		container.fenceOffset = p.indent
+		p.advanceNextNonspace()
+		p.advanceOffset(fenceLength, false)
+		return LeafMatch
+	}
+	return NoMatch
+}
+
+func indentedCodeTrigger(p *Parser, container *Node) BlockStatus {
+	if p.indented && p.tip.Type != Paragraph && !p.blank {
+		p.advanceOffset(codeIndent, true)
+		p.closeUnmatchedBlocks()
+		p.addChild(CodeBlock, p.offset)
+		return LeafMatch
+	}
+	return NoMatch
+}
+
+func htmlBlockTrigger(p *Parser, container *Node) BlockStatus {
+	if p.indented || peek(p.currentLine, p.nextNonspace) != '<' {
+		return NoMatch
+	}
+	s := p.currentLine[p.nextNonspace:]
+	for blockType := 1; blockType <= 7; blockType += 1 {
+		// type 7 can't interrupt a paragraph
+		if reHtmlBlockOpen[blockType].Match(s) && (blockType < 7 || container.Type != Paragraph) {
+			p.closeUnmatchedBlocks()
+			// we don't adjust p.offset; spaces are part of the HTML block
+			b := p.addChild(HtmlBlock, p.offset)
+			b.htmlBlockType = blockType
+			return LeafMatch
+		}
+	}
+	return NoMatch
+}
+
+func setextHeaderTrigger(p *Parser, container *Node) BlockStatus {
+	if p.indented || container.Type != Paragraph {
+		return NoMatch
+	}
+	c := peek(p.currentLine, p.nextNonspace)
+	if (c != '=' && c != '-') || scanUnderline(p.currentLine[p.nextNonspace:], c) == 0 {
+		return NoMatch
+	}
+	p.closeUnmatchedBlocks()
+	// the paragraph may consist of nothing but reference definitions
+	p.parseReferenceDefs(container)
+	if isBlank(container.content) {
+		return NoMatch
+	}
+	// the header starts where the paragraph did, which ends on the line
+	// before the underline
+	pos := p.slab.sourceRange()
+	*pos = *container.sourcePos
+	header := p.slab.node(Header, pos)
+	header.level = 2
+	if c == '=' {
+		header.level = 1
+	}
+	header.content = container.content
+	header.lineOffsets = container.lineOffsets
+	header.dropped = container.dropped
+	container.insertAfter(header)
+	container.Unlink()
+	container.open = false
+	container.sourcePos.endLine = p.lineNumber - 1
+	container.sourcePos.endChar = p.lastLineLength
+	p.tip = header
+	if p.options.Tracer != nil {
+		p.options.Tracer.CloseBlock(container)
+		p.options.Tracer.OpenBlock(header)
+	}
+	p.advanceOffset(uint32(len(p.currentLine))-p.offset, false)
+	return LeafMatch
+}
+
+// isHrule returns true if line is a horizontal rule: three or more *s, -s or
+// _s, possibly with spaces and tabs between and after them.
+func isHrule(line []byte) bool {
+	c := peek(line, 0)
+	if c != '*' && c != '-' && c != '_' {
+		return false
+	}
+	count := 0
+	for _, b := range line {
+		if b == c {
+			count += 1
+		} else if !isSpaceOrTab(b) {
+			return false
+		}
+	}
+	return count >= 3
+}
+
 func hruleTrigger(p *Parser, container *Node) BlockStatus {
-	match := reHrule.Find(p.currentLine[p.nextNonspace:])
-	if !p.indented && match != nil {
+	if !p.indented && isHrule(p.currentLine[p.nextNonspace:]) {
 		p.closeUnmatchedBlocks()
 		p.addChild(HorizontalRule, p.nextNonspace)
 		p.advanceOffset(uint32(len(p.currentLine))-p.offset, false)
@@ -427,6 +1317,49 @@ func hruleTrigger(p *Parser, container *Node) BlockStatus {
 	}
 }
 
+// scanUnderline returns the number of cs that line starts with if nothing
+// but spaces and tabs follow them, and 0 otherwise. It recognizes setext
+// header underlines and closing code fences.
+func scanUnderline(line []byte, c byte) int {
+	count := 0
+	for count < len(line) && line[count] == c {
+		count += 1
+	}
+	for _, b := range line[count:] {
+		if !isSpaceOrTab(b) {
+			return 0
+		}
+	}
+	return count
+}
+
+// isBlank returns true if s consists of nothing but whitespace.
+func isBlank(s []byte) bool {
+	for _, c := range s {
+		switch c {
+		case ' ', '\t', '\f', '\v', '\r', '\n':
+			break
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// trailingBlankLines returns where the newlines and spaces at the end of s
+// begin with a newline, or len(s) if s doesn't end in a newline followed by
+// spaces. It matches /(\n *)+$/ without a regexp.
+func trailingBlankLines(s []byte) int {
+	start := len(s)
+	for start > 0 && (s[start-1] == ' ' || s[start-1] == '\n') {
+		start -= 1
+	}
+	if i := bytes.IndexByte(s[start:], '\n'); i >= 0 {
+		return start + i
+	}
+	return len(s)
+}
+
 func peek(line []byte, pos uint32) byte {
 	if pos < uint32(len(line)) {
 		return line[pos]
@@ -438,8 +1371,8 @@ func blockquoteTrigger(p *Parser, container *Node) BlockStatus {
 	if !p.indented && peek(p.currentLine, p.nextNonspace) == '>' {
 		p.advanceNextNonspace()
 		p.advanceOffset(1, false)
-		if peek(p.currentLine, p.offset) == ' ' {
-			p.advanceOffset(1, false)
+		if isSpaceOrTab(peek(p.currentLine, p.offset)) {
+			p.advanceOffset(1, true)
 		}
 		p.closeUnmatchedBlocks()
 		p.addChild(BlockQuote, p.nextNonspace)
@@ -449,19 +1382,134 @@ func blockquoteTrigger(p *Parser, container *Node) BlockStatus {
 	}
 }
 
-func (p *Parser) incorporateLine(line []byte) {
+// scanOrderedListMarker returns the number of digits of the ordered list
+// marker at the start of line, one to nine digits followed by a '.' or a ')',
+// or 0 if line doesn't start with one.
+func scanOrderedListMarker(line []byte) int {
+	digits := 0
+	for digits < len(line) && line[digits] >= '0' && line[digits] <= '9' {
+		digits += 1
+	}
+	if digits == 0 || digits > 9 {
+		return 0
+	}
+	if c := peek(line, uint32(digits)); c != '.' && c != ')' {
+		return 0
+	}
+	return digits
+}
+
+func isSpaceOrTab(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// parseListMarker parses a list marker at the current position and returns
+// the list data, or nil if the line doesn't start a list item.
+func parseListMarker(p *Parser, container *Node) *ListData {
+	if p.indent >= 4 {
+		return nil
+	}
+	rest := p.currentLine[p.nextNonspace:]
+	data := ListData{
+		tight:        true, // lists are tight by default
+		markerOffset: p.indent,
+	}
+	var markerLen uint32
+	if c := peek(rest, 0); c == '*' || c == '+' || c == '-' {
+		data.listType = BulletList
+		data.bulletChar = c
+		markerLen = 1
+	} else if digits := scanOrderedListMarker(rest); digits > 0 &&
+		(container.Type != Paragraph || (digits == 1 && rest[0] == '1')) {
+		start, _ := strconv.Atoi(string(rest[:digits]))
+		data.listType = OrderedList
+		data.start = uint32(start)
+		data.delimiter = rest[digits]
+		markerLen = uint32(digits + 1)
+	} else {
+		return nil
+	}
+	// make sure we have spaces after
+	nextc := peek(p.currentLine, p.nextNonspace+markerLen)
+	if !(nextc == 0 || isSpaceOrTab(nextc)) {
+		return nil
+	}
+	// if it interrupts paragraph, make sure first line isn't blank
+	if container.Type == Paragraph &&
+		isBlank(p.currentLine[p.nextNonspace+markerLen:]) {
+		return nil
+	}
+	// we've got a match! advance offset and calculate padding
+	p.advanceNextNonspace()          // to start of marker
+	p.advanceOffset(markerLen, true) // to end of marker
+	spacesStartCol := p.column
+	spacesStartOffset := p.offset
+	for p.column-spacesStartCol < 5 && isSpaceOrTab(peek(p.currentLine, p.offset)) {
+		p.advanceOffset(1, true)
+	}
+	blankItem := p.offset >= uint32(len(p.currentLine))
+	spacesAfterMarker := p.column - spacesStartCol
+	if spacesAfterMarker >= 5 || spacesAfterMarker < 1 || blankItem {
+		data.padding = markerLen + 1
+		p.column = spacesStartCol
+		p.offset = spacesStartOffset
+		if isSpaceOrTab(peek(p.currentLine, p.offset)) {
+			p.advanceOffset(1, true)
+		}
+	} else {
+		data.padding = markerLen + spacesAfterMarker
+	}
+	// only allocated once there's a match, since most lines have no marker
+	matched := data
+	return &matched
+}
+
+// listsMatch returns true if the two list items are of the same type, with
+// the same delimiter and bullet character. This is used in agglomerating list
+// items into lists.
+func listsMatch(listData, itemData *ListData) bool {
+	return listData.listType == itemData.listType &&
+		listData.delimiter == itemData.delimiter &&
+		listData.bulletChar == itemData.bulletChar
+}
+
+func listItemTrigger(p *Parser, container *Node) BlockStatus {
+	if p.indented && container.Type != List {
+		return NoMatch
+	}
+	data := parseListMarker(p, container)
+	if data == nil {
+		return NoMatch
+	}
+	p.closeUnmatchedBlocks()
+	// add the list if needed
+	if p.tip.Type != List || !listsMatch(p.tip.listData, data) {
+		container = p.addChild(List, p.nextNonspace)
+		container.listData = data
+	}
+	// add the list item
+	container = p.addChild(Item, p.nextNonspace)
+	container.listData = data
+	return ContainerMatch
+}
+
+func (p *Parser) incorporateLine(line []byte) error {
 	allMatched := true
 	container := p.doc
 	p.oldTip = p.tip
 	p.offset = 0
+	p.column = 0
+	p.partiallyConsumedTab = false
 	p.lineNumber += 1
 	p.currentLine = line
-	fmt.Printf("%3d: %s\n", p.lineNumber, string(line))
+	if p.options.Tracer != nil {
+		p.options.Tracer.Line(int(p.lineNumber), line)
+	}
 	lastChild := container.lastChild
 	for lastChild != nil && lastChild.open {
 		container = lastChild
 		p.findNextNonspace()
-		switch blockHandlers[container.Type].Continue(p, container) {
+		switch status := p.blockHandlers[container.Type].Continue(p, container); status {
 		case Matched: // matched, keep going
 			break
 		case NotMatched: // failed to match a block
@@ -469,18 +1517,19 @@ func (p *Parser) incorporateLine(line []byte) {
 			break
 		case Completed: // we've hit end of line for fenced code close and can return
 			p.lastLineLength = uint32(len(line))
-			return
+			return nil
 		default:
-			panic("Continue returned illegal value, must be 0, 1, or 2")
+			return fmt.Errorf("mdast: line %d: %s block handler's Continue returned illegal value %d", p.lineNumber, container.Type, status)
 		}
 		if !allMatched {
 			container = container.parent // back up to last matching block
 			break
 		}
+		lastChild = container.lastChild
 	}
 	p.allClosed = container == p.oldTip
 	p.lastMatchedContainer = container
-	matchedLeaf := container.Type != Paragraph && blockHandlers[container.Type].AcceptsLines()
+	matchedLeaf := container.Type != Paragraph && p.blockHandlers[container.Type].AcceptsLines()
 	for !matchedLeaf {
 		p.findNextNonspace()
 		//if !p.indented && reMaybeSpecial.Find(line[p.nextNonspace:]) == nil {
@@ -488,8 +1537,8 @@ func (p *Parser) incorporateLine(line []byte) {
 		//	break
 		//}
 		nothingMatched := true
-		for _, trigger := range blockTriggers {
-			st := trigger(p, container)
+		for _, trigger := range p.blockTriggers {
+			st := trigger.trigger(p, container)
 			if st != NoMatch {
 				container = p.tip
 				nothingMatched = false
@@ -512,23 +1561,23 @@ func (p *Parser) incorporateLine(line []byte) {
 			container.lastChild.lastLineBlank = true
 		}
 		t := container.Type
-		lastLineBlank := p.blank /* &&
-		!(t == BlockQuote || (t == CodeBlock && container.isFenced) ||
-			(t == Item && container.firstChild == nil && container.sourcePos.line == p.lineNumber))
-		*/
+		lastLineBlank := p.blank &&
+			!(t == BlockQuote || (t == CodeBlock && container.isFenced) ||
+				(t == Item && container.firstChild == nil && container.sourcePos.line == p.lineNumber))
 		cont := container
 		for cont != nil {
 			cont.lastLineBlank = lastLineBlank
 			cont = cont.parent
 		}
-		if blockHandlers[t].AcceptsLines() {
+		if p.blockHandlers[t].AcceptsLines() {
 			p.addLine()
-			//if t == HtmlBlock &&
-			//	container.htmlBlockType >= 1 &&
-			//	container.htmlBlockType <= 5 &&
-			//	reHtmlBlockClose() {
-			//	p.finalize(container, p.lineNumber)
-			//}
+			// if HtmlBlock, check for end condition
+			if t == HtmlBlock &&
+				container.htmlBlockType >= 1 &&
+				container.htmlBlockType <= 5 &&
+				reHtmlBlockClose[container.htmlBlockType].Match(p.currentLine[p.offset:]) {
+				p.finalize(container, p.lineNumber)
+			}
 		} else if p.offset < uint32(len(line)) && !p.blank {
 			container = p.addChild(Paragraph, p.offset)
 			p.advanceNextNonspace()
@@ -536,6 +1585,19 @@ func (p *Parser) incorporateLine(line []byte) {
 		}
 	}
 	p.lastLineLength = uint32(len(line))
+	return nil
+}
+
+// parseReferenceDefs strips link reference definitions from the start of
+// block's content, adding them to the refmap.
+func (p *Parser) parseReferenceDefs(block *Node) {
+	for peek(block.content, 0) == '[' {
+		pos := p.inlineParser.parseReference(block.content, p.refmap)
+		if pos == 0 {
+			break
+		}
+		block.dropContent(pos)
+	}
 }
 
 func (p *Parser) finalize(block *Node, lineNumber uint32) {
@@ -544,13 +1606,16 @@ func (p *Parser) finalize(block *Node, lineNumber uint32) {
 	//block.sourcepos[1] = [lineNumber, this.lastLineLength];
 	block.sourcePos.endLine = lineNumber
 	block.sourcePos.endChar = p.lastLineLength
-	blockHandlers[block.Type].Finalize(p, block)
+	p.blockHandlers[block.Type].Finalize(p, block)
+	if p.options.Tracer != nil {
+		p.options.Tracer.CloseBlock(block)
+	}
 	p.tip = above
 }
 
 func (p *Parser) processInlines(ast *Node) {
 	walker := NewNodeWalker(ast)
-	for node := ast; node != nil; node, _ = walker.next() {
+	for node, _ := walker.Next(); node != nil; node, _ = walker.Next() {
 		if node.Type == Paragraph || node.Type == Header {
 			p.inlineParser.parse(node)
 		}
@@ -558,52 +1623,101 @@ func (p *Parser) processInlines(ast *Node) {
 }
 
 func (p *Parser) addLine() {
+	virtual := 0
+	if p.partiallyConsumedTab {
+		p.offset += 1 // skip over tab
+		// add space characters for the rest of the tab
+		virtual = int(4 - (p.column % 4))
+	}
+	p.tip.lineOffsets = append(p.tip.lineOffsets, lineOffset{
+		offset:  p.tip.dropped + len(p.tip.content),
+		line:    p.lineNumber,
+		char:    p.offset + 1,
+		virtual: virtual,
+	})
+	start := p.lineStart + int(p.offset)
+	end := p.lineStart + len(p.currentLine) + 1 // including the newline
+	if virtual == 0 && end <= len(p.input) && p.input[end-1] == '\n' {
+		// the line and its newline are in the input as they are: if they
+		// follow the content, use the input instead of copying them. The
+		// capacity is capped so that an append never writes to the input.
+		// Lines ending in "\r\n" or "\r" are copied, with a "\n" instead.
+		if len(p.tip.content) == 0 {
+			p.tip.content = p.input[start:end:end]
+			p.aliased, p.aliasedEnd = p.tip, end
+			return
+		}
+		if p.aliased == p.tip && p.aliasedEnd == start {
+			p.tip.content = p.input[start-len(p.tip.content) : end : end]
+			p.aliasedEnd = end
+			return
+		}
+	}
+	for i := 0; i < virtual; i += 1 {
+		p.tip.content = append(p.tip.content, ' ')
+	}
 	p.tip.content = append(p.tip.content, p.currentLine[p.offset:]...)
 	p.tip.content = append(p.tip.content, '\n')
+	if p.aliased == p.tip {
+		p.aliased = nil // the content is a copy now
+	}
 }
 
 func (p *Parser) addChild(node NodeType, offset uint32) *Node {
-	for !blockHandlers[p.tip.Type].CanContain(node) {
+	for !p.blockHandlers[p.tip.Type].CanContain(node) {
 		p.finalize(p.tip, p.lineNumber-1)
 	}
 	column := offset + 1 // offset 0 = column 1
-	pos := NewSourceRange()
+	pos := p.slab.sourceRange()
 	pos.line = p.lineNumber
 	pos.char = column
-	newNode := NewNode(node, pos)
-	newNode.content = []byte{}
+	newNode := p.slab.node(node, pos)
 	p.tip.appendChild(newNode)
 	p.tip = newNode
+	if p.options.Tracer != nil {
+		p.options.Tracer.OpenBlock(newNode)
+	}
 	return newNode
 }
 
+// advanceOffset moves the current position forward by count bytes or, if
+// columns is true, by count columns. In the latter case a tab may end up
+// only partially consumed, with the remaining columns becoming part of the
+// content that follows.
 func (p *Parser) advanceOffset(count uint32, columns bool) {
-	var i uint32 = 0
-	var cols uint32 = 0
-	for {
-		if columns {
-			if cols >= count {
-				break
-			}
-		} else {
-			if i >= count {
-				break
+	for count > 0 && p.offset < uint32(len(p.currentLine)) {
+		if p.currentLine[p.offset] == '\t' {
+			charsToTab := 4 - (p.column % 4)
+			if columns {
+				p.partiallyConsumedTab = charsToTab > count
+				charsToAdvance := charsToTab
+				if charsToAdvance > count {
+					charsToAdvance = count
+				}
+				p.column += charsToAdvance
+				if !p.partiallyConsumedTab {
+					p.offset += 1
+				}
+				count -= charsToAdvance
+			} else {
+				p.partiallyConsumedTab = false
+				p.column += charsToTab
+				p.offset += 1
+				count -= 1
 			}
-		}
-		if p.currentLine[p.offset+i] == '\t' {
-			cols += (4 - ((p.column + cols) % 4))
 		} else {
-			cols += 1
+			p.partiallyConsumedTab = false
+			p.offset += 1
+			p.column += 1 // assume ascii; block starts are ascii
+			count -= 1
 		}
-		i += 1
 	}
-	p.offset += i
-	p.column += cols
 }
 
 func (p *Parser) advanceNextNonspace() {
 	p.offset = p.nextNonspace
 	p.column = p.nextNonspaceColumn
+	p.partiallyConsumedTab = false
 }
 
 func (p *Parser) closeUnmatchedBlocks() {
@@ -640,87 +1754,89 @@ func (p *Parser) findNextNonspace() {
 	p.indented = p.indent >= 4
 }
 
-func (p *Parser) parse(input []byte) *Node {
-	p.lines = bytes.Split(input, []byte{'\n'})
-	var numLines uint32 = uint32(len(p.lines))
-	if input[len(input)-1] == '\n' {
-		// ignore last blank line created by final newline
-		numLines -= 1
+// Parse parses input into a document tree. Any input is valid Markdown, so an
+// error is only returned if something goes wrong inside the parser.
+func Parse(input []byte, opts Options) (*Node, error) {
+	return NewParser(opts).Parse(input)
+}
+
+// Parse parses input into a document tree, using the extensions registered
+// with the parser. A parser can parse any number of documents, one at a time;
+// parsers are independent of each other, so separate goroutines can parse
+// concurrently with separate parsers.
+//
+// To avoid copying, the contents of the tree's nodes may be slices of input,
+// which must not be modified while the tree is in use.
+func (p *Parser) Parse(input []byte) (doc *Node, err error) {
+	p.Reset()
+	defer func() {
+		if r := recover(); r != nil {
+			doc, err = nil, fmt.Errorf("mdast: internal error: %v", r)
+		}
+	}()
+	return p.parse(input)
+}
+
+// scanLine returns the length of the first line of s and that of the line
+// ending after it, which is "\n", "\r\n" or "\r", or none for the last line.
+func scanLine(s []byte) (length, ending int) {
+	length = bytes.IndexAny(s, "\r\n")
+	switch {
+	case length < 0:
+		return len(s), 0
+	case s[length] == '\r' && length+1 < len(s) && s[length+1] == '\n':
+		return length, 2
 	}
-	var i uint32
-	for i = 0; i < numLines; i += 1 {
-		p.incorporateLine(p.lines[i])
+	return length, 1
+}
+
+func (p *Parser) parse(input []byte) (*Node, error) {
+	p.input = input
+	var numLines uint32
+	for {
+		length, ending := scanLine(input[p.lineStart:])
+		end := p.lineStart + length
+		if err := p.incorporateLine(input[p.lineStart:end]); err != nil {
+			return nil, err
+		}
+		numLines += 1
+		// a final line ending doesn't start another line, but an empty
+		// input is a single empty line
+		if end+ending >= len(input) {
+			break
+		}
+		p.lineStart = end + ending
 	}
 	for p.tip != nil {
 		p.finalize(p.tip, numLines)
 	}
+	p.inlineParser.refmap = p.refmap
+	p.inlineParser.tracer = p.options.Tracer
 	p.processInlines(p.doc)
-	return p.doc
-}
-
-func forEachNode(root *Node, f func(node *Node, entering bool)) {
-	walker := NewNodeWalker(root)
-	node, entering := walker.next()
-	for node != nil {
-		f(node, entering)
-		node, entering = walker.next()
+	if p.options.RawHTML != RawHTMLPassThrough {
+		p.filterRawHTML()
 	}
+	return p.doc, nil
 }
 
-func dump(ast *Node, depth int) {
-	forEachNode(ast, func(node *Node, entering bool) {
-		indent := ""
-		content := node.literal
-		if content == nil {
-			content = node.content
+// filterRawHTML drops the raw HTML nodes from the document or turns them into
+// literal text, according to the RawHTML option.
+func (p *Parser) filterRawHTML() {
+	var raw []*Node
+	forEachNode(p.doc, func(node *Node, entering bool) {
+		if node.Type == HtmlBlock || node.Type == HtmlInline {
+			raw = append(raw, node)
 		}
-		fmt.Printf("%s%s (%q)\n", indent, node.Type, content)
 	})
-	/*
-		walker := NewNodeWalker(ast)
-		_, node := walker.next()
-		//for node := ast; node != nil; _, node = walker.next() {
-		for node != nil {
-			indent := ""
-			content := node.literal
-			if content == nil {
-				content = node.content
-			}
-			fmt.Printf("%s%s (%q)\n", indent, node.Type, content)
-			_, node = walker.next()
-		}
-	*/
-	/*
-		indent := ""
-		for i := 0; i < depth; i += 1 {
-			indent += "\t"
-		}
-		content := ast.literal
-		if content == nil {
-			content = ast.content
-		}
-		fmt.Printf("%s%s (%q)\n", indent, ast.Type, content)
-		//fmt.Printf("%s%#v\n", indent, ast)
-		//fmt.Printf("%s%#v\n", indent, ast.firstChild)
-		for n := ast.firstChild; n != nil; n = n.next {
-			dump(n, depth+1)
+	for _, node := range raw {
+		if p.options.RawHTML == RawHTMLDrop {
+			node.Unlink()
+		} else if node.Type == HtmlInline {
+			node.Type = Text
+		} else {
+			node.Type = Paragraph
+			node.appendChild(text(node.literal))
+			node.literal = nil
 		}
-	*/
-}
-
-func main() {
-	//fmt.Printf("%#v\n", os.Args)
-	if len(os.Args) < 2 {
-		fmt.Println("usage: go run ast.go file.md")
-		return
-	}
-	bytes, err := ioutil.ReadFile(os.Args[1])
-	if err != nil {
-		panic(err)
 	}
-	p := NewParser()
-	ast := p.parse(bytes)
-	dump(ast, 0)
-	println("================")
-	println(string(render(ast)))
 }
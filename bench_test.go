@@ -0,0 +1,98 @@
+package mdast
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func benchmarkParse(b *testing.B, input []byte) {
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i += 1 {
+		if _, err := Parse(input, Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkParseSmall parses a short document, test.md.
+func BenchmarkParseSmall(b *testing.B) {
+	input, err := os.ReadFile("test.md")
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkParse(b, input)
+}
+
+// largeDoc returns a document made of all the spec examples, repeated.
+func largeDoc(b *testing.B) []byte {
+	examples, err := readSpec("testdata/spec.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var buff bytes.Buffer
+	for n := 0; n < 4; n += 1 {
+		for _, ex := range examples {
+			buff.WriteString(ex.markdown)
+			buff.WriteString("\n")
+		}
+	}
+	return buff.Bytes()
+}
+
+// BenchmarkParseLarge parses a document of about 60 KB.
+func BenchmarkParseLarge(b *testing.B) {
+	benchmarkParse(b, largeDoc(b))
+}
+
+// pathological holds inputs that stress the nesting of blocks, the
+// delimiter and bracket stacks and the stripping of reference definitions.
+var pathological = map[string]string{
+	"NestedBlockQuotes": strings.Repeat("> ", 10000) + "a\n",
+	"NestedLists":       nestedList(200),
+	"NestedEmphasis":    strings.Repeat("*a ", 1000) + strings.Repeat(" a*", 1000) + "\n",
+	"UnclosedEmphasis":  strings.Repeat("*a _b ", 2000) + "\n",
+	"NestedBrackets":    strings.Repeat("[", 1000) + "a" + strings.Repeat("]", 1000) + "\n",
+	"UnclosedLinks":     strings.Repeat("[a](b ", 1000) + "\n",
+	"Backticks":         strings.Repeat("a`` ", 2000) + "\n",
+	"ReferenceDefs":     referenceDefs(10000),
+}
+
+func referenceDefs(count int) string {
+	var buff bytes.Buffer
+	for i := 0; i < count; i += 1 {
+		fmt.Fprintf(&buff, "[r%d]: /u%d\n", i, i)
+	}
+	return buff.String()
+}
+
+func nestedList(depth int) string {
+	var buff bytes.Buffer
+	for i := 0; i < depth; i += 1 {
+		buff.WriteString(strings.Repeat("  ", i) + "- a\n")
+	}
+	return buff.String()
+}
+
+func BenchmarkParsePathological(b *testing.B) {
+	for name, input := range pathological {
+		b.Run(name, func(b *testing.B) {
+			benchmarkParse(b, []byte(input))
+		})
+	}
+}
+
+func BenchmarkRender(b *testing.B) {
+	doc, err := Parse(largeDoc(b), Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		RenderHTML(doc, RenderOptions{})
+	}
+}
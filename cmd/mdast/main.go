@@ -0,0 +1,38 @@
+// Command mdast parses a Markdown file, dumps its AST and renders it to HTML.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rtfb/mdast-playground"
+)
+
+func dump(ast *mdast.Node, depth int) {
+	indent := strings.Repeat("\t", depth)
+	fmt.Printf("%s%s (%q)\n", indent, ast.Type, ast.Literal())
+	for n := ast.FirstChild(); n != nil; n = n.Next() {
+		dump(n, depth+1)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("usage: mdast file.md")
+		return
+	}
+	bytes, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ast, err := mdast.Parse(bytes, mdast.Options{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	dump(ast, 0)
+	fmt.Println("================")
+	os.Stdout.Write(mdast.RenderHTML(ast, mdast.RenderOptions{}))
+}
@@ -0,0 +1,35 @@
+package mdast_test
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rtfb/mdast-playground"
+)
+
+func ExampleParse() {
+	doc, err := mdast.Parse([]byte("# Hello\n\nSome *emphasis*.\n"), mdast.Options{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for node := doc.FirstChild(); node != nil; node = node.Next() {
+		fmt.Println(node.Type, node.SourcePos())
+	}
+	// Output:
+	// Header 1:1-1:7
+	// Paragraph 3:1-3:16
+}
+
+func ExampleRenderHTML() {
+	doc, err := mdast.Parse([]byte("> a <b>tag</b>\n"), mdast.Options{RawHTML: mdast.RawHTMLEscape})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	os.Stdout.Write(mdast.RenderHTML(doc, mdast.RenderOptions{}))
+	// Output:
+	// <blockquote>
+	// <p>a &lt;b&gt;tag&lt;/b&gt;</p>
+	// </blockquote>
+}
@@ -0,0 +1,229 @@
+package mdast
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+type customType struct {
+	name      string
+	container bool
+}
+
+var (
+	customTypesMu sync.RWMutex
+	customTypes   []customType
+)
+
+// NewNodeType allocates a node type for a syntax extension. The name is what
+// the type's String method returns; container tells whether nodes of this
+// type can have children. Node types are global, so that documents can be
+// passed between parsers and renderers, and are best allocated once, during
+// initialization.
+func NewNodeType(name string, container bool) NodeType {
+	customTypesMu.Lock()
+	defer customTypesMu.Unlock()
+	customTypes = append(customTypes, customType{name: name, container: container})
+	return NodeType(len(nodeTypeNames) + len(customTypes) - 1)
+}
+
+func customNodeType(t NodeType) (customType, bool) {
+	i := int(t) - len(nodeTypeNames)
+	if i < 0 {
+		// a standard type, which needs no lock
+		return customType{}, false
+	}
+	customTypesMu.RLock()
+	defer customTypesMu.RUnlock()
+	if i >= len(customTypes) {
+		return customType{}, false
+	}
+	return customTypes[i], true
+}
+
+// BlockTrigger tries to start a block at the current position of the parser,
+// within container. If the line doesn't start a block of its kind, it returns
+// NoMatch without changing the parser's state. Otherwise, it consumes the
+// block's marker, calls CloseUnmatchedBlocks, adds the block with AddChild and
+// returns ContainerMatch if other blocks can start within the new block on
+// the same line, or LeafMatch if the rest of the line is its content.
+type BlockTrigger func(p *Parser, container *Node) BlockStatus
+
+// The priorities of the standard block triggers. Triggers with a lower
+// priority are tried first.
+const (
+	ATXHeaderPriority      = 100
+	FencedCodePriority     = 200
+	HtmlBlockPriority      = 300
+	SetextHeaderPriority   = 400
+	HorizontalRulePriority = 500
+	BlockQuotePriority     = 600
+	ListItemPriority       = 700
+	IndentedCodePriority   = 800
+)
+
+type blockTrigger struct {
+	priority int
+	trigger  BlockTrigger
+}
+
+// RegisterBlock adds a kind of block to the syntax understood by the parser.
+// The handler is used for the blocks of type t, replacing any previous
+// handler for it, so standard blocks can be overridden as well. If trigger is
+// not nil, it is tried at each line before the triggers with a higher
+// priority, and after the ones with the same or a lower priority.
+func (p *Parser) RegisterBlock(t NodeType, handler BlockHandler, trigger BlockTrigger, priority int) {
+	p.blockHandlers[t] = handler
+	if trigger == nil {
+		return
+	}
+	i := sort.Search(len(p.blockTriggers), func(i int) bool {
+		return p.blockTriggers[i].priority > priority
+	})
+	p.blockTriggers = append(p.blockTriggers, blockTrigger{})
+	copy(p.blockTriggers[i+1:], p.blockTriggers[i:])
+	p.blockTriggers[i] = blockTrigger{priority, trigger}
+}
+
+// The following methods let block triggers and handlers inspect and consume
+// the line being parsed.
+
+// CurrentLine returns the line being parsed. The returned slice must not be
+// modified.
+func (p *Parser) CurrentLine() []byte {
+	return p.currentLine
+}
+
+// LineNumber returns the number of the line being parsed, starting at 1.
+func (p *Parser) LineNumber() int {
+	return int(p.lineNumber)
+}
+
+// Offset returns the position in the current line up to which it has been
+// consumed.
+func (p *Parser) Offset() int {
+	return int(p.offset)
+}
+
+// NextNonspace returns the position of the first character after Offset that
+// is not a space or a tab.
+func (p *Parser) NextNonspace() int {
+	return int(p.nextNonspace)
+}
+
+// Indent returns the width, in columns, of the whitespace between Offset and
+// NextNonspace.
+func (p *Parser) Indent() int {
+	return int(p.indent)
+}
+
+// Indented returns true if the rest of the line is indented enough to be an
+// indented code block.
+func (p *Parser) Indented() bool {
+	return p.indented
+}
+
+// Blank returns true if the rest of the line is blank.
+func (p *Parser) Blank() bool {
+	return p.blank
+}
+
+// AdvanceOffset consumes count characters of the current line, or count
+// columns if columns is true, in which case tabs count as up to four columns.
+func (p *Parser) AdvanceOffset(count int, columns bool) {
+	p.advanceOffset(uint32(count), columns)
+}
+
+// AdvanceNextNonspace consumes the current line up to NextNonspace.
+func (p *Parser) AdvanceNextNonspace() {
+	p.advanceNextNonspace()
+}
+
+// CloseUnmatchedBlocks finalizes the blocks that were not continued by the
+// current line. Triggers call it before adding a block.
+func (p *Parser) CloseUnmatchedBlocks() {
+	p.closeUnmatchedBlocks()
+}
+
+// AddChild adds a block of type t starting at the given position of the
+// current line, finalizing open blocks until one that can contain it is
+// found, and makes it the innermost open block.
+func (p *Parser) AddChild(t NodeType, offset int) *Node {
+	return p.addChild(t, uint32(offset))
+}
+
+// Finalize closes block at the current line. A handler whose Continue finds
+// the end of its block on the current line calls it before returning
+// Completed.
+func (p *Parser) Finalize(block *Node) {
+	p.finalize(block, p.lineNumber)
+}
+
+// InlineParseFunc tries to parse an inline at the current position of p,
+// which is at one of the characters the function was registered for. If it
+// finds one, it adds it to block with AppendInline and returns true.
+// Otherwise, it returns false without consuming anything.
+type InlineParseFunc func(p *InlineParser, block *Node) bool
+
+// RegisterInline adds an inline to the syntax understood by the parser. At
+// each of the characters in chars, which must be ASCII, parse is tried before
+// the standard inlines and before the parse functions registered later.
+func (p *Parser) RegisterInline(chars string, parse InlineParseFunc) {
+	ip := p.inlineParser
+	for i := 0; i < len(chars); i += 1 {
+		ip.addSpecial(chars[i])
+		ip.extensions[chars[i]] = append(ip.extensions[chars[i]], parse)
+	}
+}
+
+// RegisterDelimiter makes runs of ch, which must be ASCII, delimiters that
+// are paired like the ones for emphasis. A pair of single delimiters becomes
+// a node of type single, and a pair of double ones a node of type double,
+// which must be containers. Using '*' or '_' changes the node types for
+// emphasis.
+func (p *Parser) RegisterDelimiter(ch byte, single, double NodeType) {
+	p.inlineParser.addSpecial(ch)
+	p.inlineParser.delimTypes[ch] = [2]NodeType{single, double}
+}
+
+// addSpecial makes parseString stop at ch.
+func (p *InlineParser) addSpecial(ch byte) {
+	if ch >= utf8.RuneSelf {
+		panic(fmt.Sprintf("mdast: inline trigger character %q is not ASCII", ch))
+	}
+	p.special[ch] = true
+}
+
+// The following methods let inline parse functions inspect and consume the
+// text being parsed.
+
+// Subject returns the text of the inlines being parsed. The returned slice
+// must not be modified.
+func (p *InlineParser) Subject() []byte {
+	return p.subject
+}
+
+// Pos returns the current position in the subject.
+func (p *InlineParser) Pos() int {
+	return p.pos
+}
+
+// Peek returns the character at the current position in the subject, and
+// false if the position is at the end of the subject.
+func (p *InlineParser) Peek() (byte, bool) {
+	if p.pos < len(p.subject) {
+		return p.subject[p.pos], true
+	}
+	return 0, false
+}
+
+// AppendInline consumes length bytes of the subject and appends node, which
+// represents them, to block.
+func (p *InlineParser) AppendInline(block, node *Node, length int) {
+	block.checkInsert(node)
+	start := p.pos
+	p.pos += length
+	p.appendInline(block, node, start)
+}
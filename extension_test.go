@@ -0,0 +1,192 @@
+package mdast
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+var (
+	admonitionNode = NewNodeType("Admonition", true)
+	mathNode       = NewNodeType("Math", false)
+	diagramNode    = NewNodeType("Diagram", false)
+)
+
+// admonitionHandler handles blocks like
+//
+//	!!! note
+//	    Content, indented by four spaces.
+type admonitionHandler struct{}
+
+func (h *admonitionHandler) Continue(p *Parser, container *Node) ContinueStatus {
+	if p.Blank() {
+		p.AdvanceNextNonspace()
+	} else if p.Indent() >= 4 {
+		p.AdvanceOffset(4, true)
+	} else {
+		return NotMatched
+	}
+	return Matched
+}
+
+func (h *admonitionHandler) Finalize(p *Parser, block *Node) {}
+
+func (h *admonitionHandler) CanContain(t NodeType) bool {
+	return t != Item
+}
+
+func (h *admonitionHandler) AcceptsLines() bool {
+	return false
+}
+
+func admonitionTrigger(p *Parser, container *Node) BlockStatus {
+	rest := p.CurrentLine()[p.NextNonspace():]
+	if p.Indented() || !bytes.HasPrefix(rest, []byte("!!! ")) {
+		return NoMatch
+	}
+	p.CloseUnmatchedBlocks()
+	block := p.AddChild(admonitionNode, p.NextNonspace())
+	block.SetInfo(bytes.TrimSpace(rest[4:]))
+	p.AdvanceOffset(len(p.CurrentLine())-p.Offset(), false)
+	return ContainerMatch
+}
+
+// fenceHandler handles leaf blocks between fences, like fenced code without
+// an info string.
+type fenceHandler struct {
+	fence []byte
+}
+
+func (h *fenceHandler) Continue(p *Parser, container *Node) ContinueStatus {
+	if bytes.HasPrefix(p.CurrentLine()[p.NextNonspace():], h.fence) {
+		p.Finalize(container)
+		return Completed
+	}
+	return Matched
+}
+
+func (h *fenceHandler) Finalize(p *Parser, block *Node) {
+	// the first line is the rest of the opening fence's line
+	content := block.Content()
+	block.SetLiteral(content[bytes.IndexByte(content, '\n')+1:])
+}
+
+func (h *fenceHandler) CanContain(t NodeType) bool {
+	return false
+}
+
+func (h *fenceHandler) AcceptsLines() bool {
+	return true
+}
+
+func fenceTrigger(t NodeType, fence string) BlockTrigger {
+	return func(p *Parser, container *Node) BlockStatus {
+		if p.Indented() || !bytes.HasPrefix(p.CurrentLine()[p.NextNonspace():], []byte(fence)) {
+			return NoMatch
+		}
+		p.CloseUnmatchedBlocks()
+		p.AddChild(t, p.NextNonspace())
+		p.AdvanceNextNonspace()
+		p.AdvanceOffset(len(fence), false)
+		return LeafMatch
+	}
+}
+
+var extensionHooks = map[NodeType]RenderHook{
+	admonitionNode: func(w io.Writer, node *Node, entering bool) {
+		if entering {
+			fmt.Fprintf(w, "<div class=\"admonition %s\">\n", EscapeHTML(node.Info()))
+		} else {
+			io.WriteString(w, "</div>\n")
+		}
+	},
+	mathNode: func(w io.Writer, node *Node, entering bool) {
+		fmt.Fprintf(w, "<div class=\"math\">%s</div>\n", EscapeHTML(node.Literal()))
+	},
+	diagramNode: func(w io.Writer, node *Node, entering bool) {
+		w.Write(Tag("pre", []Attr{{"class", []byte("mermaid")}}, false))
+		w.Write(EscapeHTML(node.Literal()))
+		w.Write(Tag("/pre", nil, false))
+		io.WriteString(w, "\n")
+	},
+}
+
+func newExtendedParser() *Parser {
+	p := NewParser(Options{})
+	p.RegisterBlock(admonitionNode, &admonitionHandler{}, admonitionTrigger, BlockQuotePriority)
+	p.RegisterBlock(mathNode, &fenceHandler{[]byte("$$")}, fenceTrigger(mathNode, "$$"), FencedCodePriority)
+	// before fenced code, which would match the same fence
+	p.RegisterBlock(diagramNode, &fenceHandler{[]byte("```")}, fenceTrigger(diagramNode, "```mermaid"), FencedCodePriority-1)
+	return p
+}
+
+const extensionInput = `!!! warning
+    Do not *panic*.
+
+    $$
+    a < b
+    $$
+
+` + "```mermaid\nA --> B\n```\n\n```go\nx := 1\n```\n"
+
+func TestBlockExtension(t *testing.T) {
+	doc, err := newExtendedParser().Parse([]byte(extensionInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, doc)
+	if got, want := walk(doc), "+Document +Admonition +Paragraph Text +Emph Text -Emph Text -Paragraph Math -Admonition Diagram CodeBlock -Document"; got != want {
+		t.Errorf("walk is\n%q, want\n%q", got, want)
+	}
+	adm := doc.firstChild
+	if got, want := adm.SourcePos().String(), "1:1-7:0"; got != want {
+		t.Errorf("admonition source position is %s, want %s", got, want)
+	}
+	want := `<div class="admonition warning">
+<p>Do not <em>panic</em>.</p>
+<div class="math">a &lt; b
+</div>
+</div>
+<pre class="mermaid">A --&gt; B
+</pre>
+<pre><code class="language-go">x := 1
+</code></pre>
+`
+	if got := string(RenderHTML(doc, RenderOptions{Hooks: extensionHooks})); got != want {
+		t.Errorf("rendered as\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBlockExtensionIsPerParser(t *testing.T) {
+	newExtendedParser()
+	doc, err := Parse([]byte(extensionInput), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := types(doc), "Paragraph CodeBlock CodeBlock CodeBlock"; got != want {
+		t.Errorf("children are %q, want %q", got, want)
+	}
+}
+
+func TestRenderHookOverride(t *testing.T) {
+	doc := mustParse(t, "# Title\n\ntext\n")
+	hooks := map[NodeType]RenderHook{
+		Header: func(w io.Writer, node *Node, entering bool) {
+			if entering {
+				fmt.Fprintf(w, "<h%d class=\"title\">", node.Level()+1)
+			} else {
+				fmt.Fprintf(w, "</h%d>\n", node.Level()+1)
+			}
+		},
+	}
+	want := "<h2 class=\"title\">Title</h2>\n<p>text</p>\n"
+	if got := string(RenderHTML(doc, RenderOptions{Hooks: hooks})); got != want {
+		t.Errorf("rendered as %q, want %q", got, want)
+	}
+	// custom nodes without a hook only render their children
+	adm, _ := newExtendedParser().Parse([]byte("!!! note\n    text\n"))
+	if got, want := string(RenderHTML(adm, RenderOptions{})), "<p>text</p>\n"; got != want {
+		t.Errorf("rendered as %q, want %q", got, want)
+	}
+}
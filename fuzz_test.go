@@ -0,0 +1,73 @@
+package mdast
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	seed, err := os.ReadFile("test.md")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	for _, s := range []string{
+		"",
+		"\n",
+		"\t",
+		"> \t- \t```\n\t\n",
+		"- a\n -\n\n  b",
+		"[a]: <b\n[a]\n",
+		"*a **b* c**",
+		"<!-- x",
+		"\x00\xff\r\n",
+		"*\xff tail text here*",
+		"[x](/u\xffv)",
+	} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, input []byte) {
+		doc, err := Parse(input, Options{})
+		if err != nil {
+			t.Fatalf("parse %q: %v", input, err)
+		}
+		checkTree(t, doc)
+		RenderHTML(doc, RenderOptions{SourcePos: true})
+		// none of the bytes of plain text is markup, so all of them must
+		// end up in the text of the document
+		plain := plainText(input)
+		doc, err = Parse(plain, Options{})
+		if err != nil {
+			t.Fatalf("parse %q: %v", plain, err)
+		}
+		var text []byte
+		forEachNode(doc, func(node *Node, entering bool) {
+			if node.Type == Text {
+				text = append(text, node.literal...)
+			}
+		})
+		if !bytes.Equal(text, plain) {
+			t.Errorf("text of %q is %q", plain, text)
+		}
+		for _, mode := range []RawHTMLMode{RawHTMLDrop, RawHTMLEscape} {
+			doc, err := Parse(input, Options{RawHTML: mode})
+			if err != nil {
+				t.Fatalf("parse %q: %v", input, err)
+			}
+			RenderHTML(doc, RenderOptions{})
+		}
+	})
+}
+
+// plainText keeps the letters, digits, spaces and non-ASCII bytes of input,
+// without leading and trailing spaces.
+func plainText(input []byte) []byte {
+	var plain []byte
+	for _, c := range input {
+		if c == ' ' || c >= 0x80 || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			plain = append(plain, c)
+		}
+	}
+	return bytes.Trim(plain, " ")
+}
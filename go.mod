@@ -0,0 +1,3 @@
+module github.com/rtfb/mdast-playground
+
+go 1.21
@@ -1,39 +1,222 @@
-package main
+package mdast
 
 import (
 	"bytes"
+	"html"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	tagName               = `[A-Za-z][A-Za-z0-9-]*`
+	attributeName         = `[a-zA-Z_:][a-zA-Z0-9:._-]*`
+	unquotedValue         = "[^\"'=<>`\\x00-\\x20]+"
+	singleQuotedValue     = `'[^']*'`
+	doubleQuotedValue     = `"[^"]*"`
+	attributeValue        = `(?:` + unquotedValue + `|` + singleQuotedValue + `|` + doubleQuotedValue + `)`
+	attributeValueSpec    = `(?:\s*=\s*` + attributeValue + `)`
+	attribute             = `(?:\s+` + attributeName + attributeValueSpec + `?)`
+	openTag               = `<` + tagName + attribute + `*\s*/?>`
+	closeTag              = `</` + tagName + `\s*[>]`
+	htmlComment           = `<!-->|<!--->|<!--[\s\S]*?-->`
+	processingInstruction = `[<][?][\s\S]*?[?][>]`
+	declaration           = `<![A-Za-z]+[^>]*>`
+	cdata                 = `<!\[CDATA\[[\s\S]*?\]\]>`
+	htmlTag               = `(?:` + openTag + `|` + closeTag + `|` + htmlComment + `|` +
+		processingInstruction + `|` + declaration + `|` + cdata + `)`
+	// specialChars are the characters at which parseString stops, for
+	// parseInline to check whether they start an inline
+	specialChars = "\n`[]\\!<&*_'\""
+
+	escapable = "[!\"#$%&'()*+,./:;<=>?@[\\\\\\]^_`{|}~-]"
+	entity    = `&(?:#x[a-f0-9]{1,6}|#[0-9]{1,7}|[a-z][a-z0-9]{1,31});`
 )
 
 var (
-	reMain = regexp.MustCompile("^[^\\n`\\[\\]\\!<&*_'\"]+")
+	reHtmlTag               = regexp.MustCompile(`(?i)^` + htmlTag)
+	reSpnl                  = regexp.MustCompile("^ *(?:\n *)?")
+	reLinkTitle             = regexp.MustCompile(`^(?:"(?:\\.|[^"\\\x00])*"|'(?:\\.|[^'\\\x00])*'|\((?:\\.|[^)\\\x00])*\))`)
+	reLinkDestinationBraces = regexp.MustCompile(`^<(?:[^<>\n\\\x00]|\\.)*>`)
+	reSpaceAtEndOfLine      = regexp.MustCompile("^ *(?:\n|$)")
+	reTicks                 = regexp.MustCompile("`+")
+	reTicksHere             = regexp.MustCompile("^`+")
+	reEntityHere            = regexp.MustCompile("(?i)^" + entity)
+	reEntityOrEscapedChar   = regexp.MustCompile("(?i)\\\\" + escapable + "|" + entity)
 )
 
+// reference is a link reference definition, collected from the document and
+// looked up by its normalized label.
+type reference struct {
+	destination []byte
+	title       []byte
+}
+
+// delimiter is an entry in the delimiter stack, which keeps track of the runs
+// of emphasis characters that can potentially open or close emphasis.
+type delimiter struct {
+	cc         byte // delimiter character
+	numDelims  int  // delimiters still available for matching
+	origDelims int  // length of the original run, needed for the rule of three
+	node       *Node
+	previous   *delimiter
+	next       *delimiter
+	canOpen    bool
+	canClose   bool
+}
+
+// bracket is an entry in the bracket stack, which keeps track of the '[' and
+// '![' that can potentially open a link or an image.
+type bracket struct {
+	node              *Node
+	previous          *bracket
+	previousDelimiter *delimiter // delimiter stack top when the bracket was seen
+	index             int        // position of the bracket in the subject
+	image             bool
+	active            bool // false if the bracket can no longer open a link
+	bracketAfter      bool // true if another bracket was seen after this one
+}
+
+// span is the extent of an inline node in the subject, as byte offsets.
+type span struct {
+	start int
+	end   int
+}
+
+// InlineParser parses the inline content of the blocks of a Parser. It is
+// passed to the functions registered with RegisterInline.
 type InlineParser struct {
-	subject []byte
-	pos     int
+	subject    []byte
+	pos        int
+	delimiters *delimiter // top of the delimiter stack
+	brackets   *bracket   // top of the bracket stack
+	refmap     map[string]*reference
+	block      *Node          // block being parsed
+	base       int            // offset of the subject in the block's content
+	spans      map[*Node]span // extents of the inlines parsed so far
+	tracer     Tracer
+	slab       *nodeSlab // shared with the block parser
+	special    [256]bool // characters that may start an inline
+	extensions map[byte][]InlineParseFunc
+	delimTypes map[byte][2]NodeType // node types for a single and a double delimiter
 }
 
-func NewInlineParser() *InlineParser {
+func newInlineParser() *InlineParser {
 	return &InlineParser{
-		subject: []byte{},
-		pos:     0,
+		subject:    []byte{},
+		pos:        0,
+		delimiters: nil,
+		brackets:   nil,
+		refmap:     map[string]*reference{},
+		block:      nil,
+		base:       0,
+		spans:      map[*Node]span{},
+		special:    defaultSpecial,
+		extensions: map[byte][]InlineParseFunc{},
+		delimTypes: map[byte][2]NodeType{},
+		slab:       &nodeSlab{},
 	}
 }
 
+// reset drops the references to the last block parsed.
+func (p *InlineParser) reset() {
+	p.subject = nil
+	p.pos = 0
+	p.delimiters = nil
+	p.brackets = nil
+	p.block = nil
+	p.base = 0
+	for node := range p.spans {
+		delete(p.spans, node)
+	}
+}
+
+// defaultSpecial marks the specialChars.
+var defaultSpecial = func() (special [256]bool) {
+	for i := 0; i < len(specialChars); i += 1 {
+		special[specialChars[i]] = true
+	}
+	return special
+}()
+
 func text(s []byte) *Node {
-	node := NewNode(Text, NewSourceRange())
+	node := NewNode(Text, nil)
 	node.literal = s
 	return node
 }
 
+// newNode is NewNode for the inlines, which get their source positions once
+// the block is parsed.
+func (p *InlineParser) newNode(typ NodeType) *Node {
+	return p.slab.node(typ, nil)
+}
+
+// text returns a Text node for s, which is usually a slice of the subject.
+func (p *InlineParser) text(s []byte) *Node {
+	node := p.newNode(Text)
+	node.literal = s
+	return node
+}
+
+// appendInline appends node to block and records that it extends from start
+// to the current position in the subject.
+func (p *InlineParser) appendInline(block, node *Node, start int) {
+	block.appendChild(node)
+	p.spans[node] = span{start, p.pos}
+}
+
+// peek returns the byte at the current position, or 255 at the end of the
+// subject. Since 255 can be in the subject as well, it is only fit for
+// comparing with ASCII characters; the end of the subject is p.pos reaching
+// len(p.subject).
 func (p *InlineParser) peek() byte {
 	if p.pos < len(p.subject) {
 		return p.subject[p.pos]
 	}
-	return 255 // XXX: figure out invalid values
+	return 255
+}
+
+// match tries to find re in the subject at the current position. On success it
+// advances the position past the match and returns the matched bytes,
+// otherwise it returns nil and leaves the position unchanged.
+func (p *InlineParser) match(re *regexp.Regexp) []byte {
+	loc := re.FindIndex(p.subject[p.pos:])
+	if loc == nil {
+		return nil
+	}
+	start := p.pos + loc[0]
+	p.pos += loc[1]
+	return p.subject[start:p.pos]
+}
+
+// spnl parses optional spaces, with at most one newline among them.
+func (p *InlineParser) spnl() bool {
+	p.match(reSpnl)
+	return true
+}
+
+func isWhitespaceChar(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
 }
 
+func isEscapable(c byte) bool {
+	return bytes.IndexByte([]byte("!\"#$%&'()*+,./:;<=>?@[\\]^_`{|}~-"), c) >= 0
+}
+
+func isUnicodeWhitespace(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\f' || r == '\r' || unicode.Is(unicode.Zs, r)
+}
+
+func isUnicodePunctuation(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// scanDelims scans a sequence of characters ch at the current position and
+// determines whether it can open and/or close emphasis, according to the
+// left- and right-flanking rules. The position is left unchanged.
 func (p *InlineParser) scanDelims(ch byte) (numDelims int, canOpen, canClose bool) {
 	numDelims = 0
 	startPos := p.pos
@@ -46,71 +229,790 @@ func (p *InlineParser) scanDelims(ch byte) (numDelims int, canOpen, canClose boo
 			p.pos += 1
 		}
 	}
+	if numDelims == 0 {
+		return 0, false, false
+	}
+	charBefore := '\n'
+	if startPos > 0 {
+		charBefore, _ = utf8.DecodeLastRune(p.subject[:startPos])
+	}
+	charAfter := '\n'
+	if p.pos < len(p.subject) {
+		charAfter, _ = utf8.DecodeRune(p.subject[p.pos:])
+	}
+	afterIsWhitespace := isUnicodeWhitespace(charAfter)
+	afterIsPunctuation := isUnicodePunctuation(charAfter)
+	beforeIsWhitespace := isUnicodeWhitespace(charBefore)
+	beforeIsPunctuation := isUnicodePunctuation(charBefore)
+	leftFlanking := !afterIsWhitespace &&
+		(!afterIsPunctuation || beforeIsWhitespace || beforeIsPunctuation)
+	rightFlanking := !beforeIsWhitespace &&
+		(!beforeIsPunctuation || afterIsWhitespace || afterIsPunctuation)
+	switch ch {
+	case '_':
+		canOpen = leftFlanking && (!rightFlanking || beforeIsPunctuation)
+		canClose = rightFlanking && (!leftFlanking || afterIsPunctuation)
+	case '\'', '"':
+		canOpen = leftFlanking && !rightFlanking
+		canClose = rightFlanking
+	default:
+		canOpen = leftFlanking
+		canClose = rightFlanking
+	}
 	p.pos = startPos
-	return numDelims, false, false
+	return numDelims, canOpen, canClose
 }
 
 func (p *InlineParser) handleDelim(ch byte, block *Node) bool {
-	numDelims, _, _ := p.scanDelims(ch)
+	numDelims, canOpen, canClose := p.scanDelims(ch)
 	if numDelims < 1 {
 		return false
 	}
 	startPos := p.pos
-	println("startPos = ", startPos)
 	p.pos += numDelims
 	var contents []byte
 	if ch == '\'' || ch == '"' {
 		contents = []byte{ch}
 	} else {
 		contents = p.subject[startPos:p.pos]
-		println("--- ", string(contents))
 	}
-	node := text(contents)
-	block.appendChild(node)
-	// TODO: add entry to stack
+	node := p.text(contents)
+	p.appendInline(block, node, startPos)
+	// add entry to stack for this opener
+	p.delimiters = &delimiter{
+		cc:         ch,
+		numDelims:  numDelims,
+		origDelims: numDelims,
+		node:       node,
+		previous:   p.delimiters,
+		next:       nil,
+		canOpen:    canOpen,
+		canClose:   canClose,
+	}
+	if p.delimiters.previous != nil {
+		p.delimiters.previous.next = p.delimiters
+	}
+	if p.tracer != nil {
+		p.tracer.Delimiter(node, canOpen, canClose)
+	}
+	return true
+}
+
+func (p *InlineParser) removeDelimiter(delim *delimiter) {
+	if delim.previous != nil {
+		delim.previous.next = delim.next
+	}
+	if delim.next == nil {
+		// top of stack
+		p.delimiters = delim.previous
+	} else {
+		delim.next.previous = delim.previous
+	}
+}
+
+func removeDelimitersBetween(bottom, top *delimiter) {
+	if bottom.next != top {
+		bottom.next = top
+		top.previous = bottom
+	}
+}
+
+func (p *InlineParser) addBracket(node *Node, index int, image bool) {
+	if p.brackets != nil {
+		p.brackets.bracketAfter = true
+	}
+	p.brackets = &bracket{
+		node:              node,
+		previous:          p.brackets,
+		previousDelimiter: p.delimiters,
+		index:             index,
+		image:             image,
+		active:            true,
+	}
+}
+
+func (p *InlineParser) removeBracket() {
+	p.brackets = p.brackets.previous
+}
+
+func (p *InlineParser) parseOpenBracket(block *Node) bool {
+	startPos := p.pos
+	p.pos += 1
+	node := p.text(p.subject[startPos:p.pos])
+	p.appendInline(block, node, startPos)
+	p.addBracket(node, startPos, false)
+	return true
+}
+
+// parseBang handles '!', which is either the start of an image or a literal.
+func (p *InlineParser) parseBang(block *Node) bool {
+	startPos := p.pos
+	p.pos += 1
+	if p.peek() == '[' {
+		p.pos += 1
+		node := p.text(p.subject[startPos:p.pos])
+		p.appendInline(block, node, startPos)
+		p.addBracket(node, startPos+1, true)
+	} else {
+		p.appendInline(block, p.text(p.subject[startPos:p.pos]), startPos)
+	}
+	return true
+}
+
+// parseBackticks attempts to parse a code span, which is a run of backticks
+// closed by a run of the same length. If there is no matching closer, the
+// opening run is a literal.
+func (p *InlineParser) parseBackticks(block *Node) bool {
+	ticks := p.match(reTicksHere)
+	if ticks == nil {
+		return false
+	}
+	afterOpenTicks := p.pos
+	for matched := p.match(reTicks); matched != nil; matched = p.match(reTicks) {
+		if len(matched) != len(ticks) {
+			continue
+		}
+		contents := bytes.Replace(p.subject[afterOpenTicks:p.pos-len(ticks)], []byte{'\n'}, []byte{' '}, -1)
+		// strip a single space from each side, unless it's all spaces
+		if len(contents) > 2 && contents[0] == ' ' && contents[len(contents)-1] == ' ' &&
+			len(bytes.Trim(contents, " ")) > 0 {
+			contents = contents[1 : len(contents)-1]
+		}
+		node := p.newNode(Code)
+		node.literal = contents
+		p.appendInline(block, node, afterOpenTicks-len(ticks))
+		return true
+	}
+	// if we got here, we didn't match a closing backtick sequence
+	p.pos = afterOpenTicks
+	p.appendInline(block, p.text(ticks), afterOpenTicks-len(ticks))
+	return true
+}
+
+// parseBackslash parses a backslash, which escapes the following ASCII
+// punctuation character or, at the end of a line, makes a hard line break.
+// It is a literal otherwise.
+func (p *InlineParser) parseBackslash(block *Node) bool {
+	startPos := p.pos
+	p.pos += 1
+	if p.peek() == '\n' {
+		p.pos += 1 // the line break includes the newline
+		p.appendInline(block, p.newNode(Hardbreak), startPos)
+	} else if p.pos < len(p.subject) && isEscapable(p.subject[p.pos]) {
+		p.pos += 1
+		p.appendInline(block, p.text(p.subject[p.pos-1:p.pos]), startPos)
+	} else {
+		p.appendInline(block, p.text(p.subject[startPos:startPos+1]), startPos)
+	}
+	return true
+}
+
+// parseNewline parses a line ending, which is a hard line break if preceded
+// by two or more spaces, and a soft one otherwise.
+func (p *InlineParser) parseNewline(block *Node) bool {
+	startPos := p.pos
+	p.pos += 1 // assume we're at a \n
+	// check previous node for trailing spaces
+	lastc := block.lastChild
+	if lastc != nil && lastc.Type == Text && bytes.HasSuffix(lastc.literal, []byte(" ")) {
+		hardbreak := bytes.HasSuffix(lastc.literal, []byte("  "))
+		trimmed := bytes.TrimRight(lastc.literal, " ")
+		// the trailing spaces now belong to the line break
+		startPos -= len(lastc.literal) - len(trimmed)
+		lastc.literal = trimmed
+		if sp, ok := p.spans[lastc]; ok {
+			p.spans[lastc] = span{sp.start, startPos}
+		}
+		if hardbreak {
+			p.appendInline(block, p.newNode(Hardbreak), startPos)
+		} else {
+			p.appendInline(block, p.newNode(Softbreak), startPos)
+		}
+	} else {
+		p.appendInline(block, p.newNode(Softbreak), startPos)
+	}
+	// gobble leading spaces in next line
+	for p.peek() == ' ' {
+		p.pos += 1
+	}
+	return true
+}
+
+// parseEntity attempts to parse an HTML entity, which is replaced with the
+// character(s) it stands for.
+func (p *InlineParser) parseEntity(block *Node) bool {
+	loc := reEntityHere.FindIndex(p.subject[p.pos:])
+	if loc == nil {
+		return false
+	}
+	decoded, ok := decodeEntity(p.subject[p.pos : p.pos+loc[1]])
+	if !ok {
+		return false
+	}
+	startPos := p.pos
+	p.pos += loc[1]
+	p.appendInline(block, p.text(decoded), startPos)
+	return true
+}
+
+// decodeEntity decodes a named, decimal or hexadecimal entity. Named entities
+// are looked up in the HTML5 entity table; ok is false for unknown names.
+func decodeEntity(ent []byte) (decoded []byte, ok bool) {
+	if ent[1] != '#' {
+		s := html.UnescapeString(string(ent))
+		// the html package also decodes the legacy entities that lack the
+		// semicolon, so "&ampfoo;" would come out as "&foo;". A complete
+		// match always yields one or two code points
+		if s == string(ent) || utf8.RuneCountInString(s) > 2 {
+			return nil, false
+		}
+		return []byte(s), true
+	}
+	var cp uint64
+	var err error
+	if ent[2] == 'x' || ent[2] == 'X' {
+		cp, err = strconv.ParseUint(string(ent[3:len(ent)-1]), 16, 32)
+	} else {
+		cp, err = strconv.ParseUint(string(ent[2:len(ent)-1]), 10, 32)
+	}
+	r := rune(cp)
+	if err != nil || cp == 0 || !utf8.ValidRune(r) {
+		r = unicode.ReplacementChar
+	}
+	return []byte(string(r)), true
+}
+
+// unescapeString replaces backslash escapes and entities in s with the
+// characters they stand for.
+func unescapeString(s []byte) []byte {
+	if bytes.IndexAny(s, "\\&") < 0 {
+		return s
+	}
+	return reEntityOrEscapedChar.ReplaceAllFunc(s, func(m []byte) []byte {
+		if m[0] == '\\' {
+			return m[1:]
+		}
+		if decoded, ok := decodeEntity(m); ok {
+			return decoded
+		}
+		return m
+	})
+}
+
+// parseLinkTitle attempts to parse a link title, sans quotes.
+func (p *InlineParser) parseLinkTitle() ([]byte, bool) {
+	title := p.match(reLinkTitle)
+	if title == nil {
+		return nil, false
+	}
+	// chop off quotes from title and unescape
+	return unescapeString(title[1 : len(title)-1]), true
+}
+
+// parseLinkDestination attempts to parse a link destination, either enclosed
+// in pointy braces or a run of non-space characters with balanced parens.
+func (p *InlineParser) parseLinkDestination() ([]byte, bool) {
+	if res := p.match(reLinkDestinationBraces); res != nil {
+		// chop off surrounding <..>
+		return unescapeString(res[1 : len(res)-1]), true
+	}
+	if p.peek() == '<' {
+		return nil, false
+	}
+	savePos := p.pos
+	openParens := 0
+	for p.pos < len(p.subject) {
+		c := p.subject[p.pos]
+		if c == '\\' && p.pos+1 < len(p.subject) && isEscapable(p.subject[p.pos+1]) {
+			p.pos += 2
+		} else if c == '(' {
+			p.pos += 1
+			openParens += 1
+		} else if c == ')' {
+			if openParens < 1 {
+				break
+			}
+			p.pos += 1
+			openParens -= 1
+		} else if isWhitespaceChar(c) || c < 0x20 {
+			break
+		} else {
+			p.pos += 1
+		}
+	}
+	if (p.pos == savePos && p.peek() != ')') || openParens != 0 {
+		p.pos = savePos
+		return nil, false
+	}
+	return unescapeString(p.subject[savePos:p.pos]), true
+}
+
+// parseCloseBracket tries to match a ']' to an opening bracket and, if the
+// text that follows is an inline destination, turns the whole thing into a
+// Link or an Image. Otherwise the ']' is a literal.
+func (p *InlineParser) parseCloseBracket(block *Node) bool {
+	p.pos += 1
+	startPos := p.pos
+	// get last [ or ![
+	opener := p.brackets
+	if opener == nil {
+		// no matched opener, just return a literal
+		p.appendInline(block, p.text(p.subject[startPos-1:startPos]), startPos-1)
+		return true
+	}
+	if !opener.active {
+		// no matched opener, just return a literal
+		p.appendInline(block, p.text(p.subject[startPos-1:startPos]), startPos-1)
+		// take opener off brackets stack
+		p.removeBracket()
+		return true
+	}
+	// if we got here, opener is a potential opener
+	isImage := opener.image
+	var dest, title []byte
+	matched := false
+	// check to see if we have an inline link
+	if p.peek() == '(' {
+		p.pos += 1
+		p.spnl()
+		if dest, matched = p.parseLinkDestination(); matched {
+			p.spnl()
+			// make sure there's a space before the title
+			if isWhitespaceChar(p.subject[p.pos-1]) {
+				title, _ = p.parseLinkTitle()
+			}
+			p.spnl()
+			matched = p.peek() == ')'
+			if matched {
+				p.pos += 1
+			}
+		}
+		if !matched {
+			p.pos = startPos
+		}
+	}
+	if !matched {
+		// next, see if there's a link label
+		beforeLabel := p.pos
+		n := p.parseLinkLabel()
+		var refLabel []byte
+		if n > 2 {
+			refLabel = p.subject[beforeLabel : beforeLabel+n]
+		} else if !opener.bracketAfter {
+			// empty or missing second label means to use the first label
+			// as the reference. The reference must not contain a bracket.
+			// If we know there's a bracket, we don't even bother checking it.
+			refLabel = p.subject[opener.index:startPos]
+		}
+		if n == 0 {
+			// if shortcut reference link, rewind before spaces we skipped
+			p.pos = startPos
+		}
+		if refLabel != nil {
+			if link, ok := p.refmap[normalizeReference(refLabel)]; ok {
+				dest = link.destination
+				title = link.title
+				matched = true
+			}
+		}
+	}
+	if !matched {
+		// no match: remove this opener from stack and return a literal
+		p.removeBracket()
+		p.pos = startPos
+		p.appendInline(block, p.text(p.subject[startPos-1:startPos]), startPos-1)
+		return true
+	}
+	typ := Link
+	if isImage {
+		typ = Image
+	}
+	node := p.newNode(typ)
+	node.destination = dest
+	node.title = title
+	tmp := opener.node.next
+	for tmp != nil {
+		next := tmp.next
+		node.appendChild(tmp)
+		tmp = next
+	}
+	p.appendInline(block, node, p.spans[opener.node].start)
+	p.processEmphasis(opener.previousDelimiter)
+	p.removeBracket()
+	opener.node.Unlink()
+	// we remove this bracket and processEmphasis will remove later
+	// delimiters. Now, for a link, we also deactivate earlier link openers
+	// (no links in links)
+	if !isImage {
+		for opener = p.brackets; opener != nil; opener = opener.previous {
+			if !opener.image {
+				opener.active = false
+			}
+		}
+	}
+	return true
+}
+
+// parseLinkLabel attempts to parse a link label at the current position and
+// returns the number of characters it occupies, including the brackets, or 0
+// if there is no label.
+func (p *InlineParser) parseLinkLabel() int {
+	if p.peek() != '[' {
+		return 0
+	}
+	for i := p.pos + 1; i < len(p.subject); i += 1 {
+		switch p.subject[i] {
+		case '\\':
+			i += 1
+		case '[':
+			return 0
+		case ']':
+			n := i + 1 - p.pos
+			if n > 1001 {
+				// a label can have at most 999 characters inside the brackets
+				return 0
+			}
+			p.pos = i + 1
+			return n
+		}
+	}
+	return 0
+}
+
+// normalizeReference normalizes a reference label: the brackets are
+// stripped, the inner whitespace collapsed and the case folded, so that
+// labels that differ only in those respects match.
+func normalizeReference(label []byte) string {
+	fields := strings.Fields(string(label[1 : len(label)-1]))
+	return strings.ToUpper(strings.ToLower(strings.Join(fields, " ")))
+}
+
+// parseReference attempts to parse a link reference definition at the start
+// of s, storing it in refmap. It returns the number of bytes consumed, or 0
+// if s doesn't start with a reference definition.
+func (p *InlineParser) parseReference(s []byte, refmap map[string]*reference) int {
+	p.subject = s
+	p.pos = 0
+	startPos := p.pos
+	// label:
+	matchChars := p.parseLinkLabel()
+	if matchChars == 0 {
+		return 0
+	}
+	rawLabel := p.subject[:matchChars]
+	// colon:
+	if p.peek() != ':' {
+		p.pos = startPos
+		return 0
+	}
+	p.pos += 1
+	// link url
+	p.spnl()
+	dest, ok := p.parseLinkDestination()
+	if !ok {
+		p.pos = startPos
+		return 0
+	}
+	beforeTitle := p.pos
+	p.spnl()
+	// the title must be separated from the destination by whitespace
+	var title []byte
+	ok = false
+	if p.pos != beforeTitle {
+		title, ok = p.parseLinkTitle()
+	}
+	if !ok {
+		title = nil
+		p.pos = beforeTitle
+	}
+	// make sure we're at line end:
+	atLineEnd := true
+	if p.match(reSpaceAtEndOfLine) == nil {
+		if title == nil {
+			atLineEnd = false
+		} else {
+			// the potential title we found is not at the line end, but it
+			// could still be a legal link reference if we discard the title
+			title = nil
+			// rewind before spaces
+			p.pos = beforeTitle
+			// and instead check if the link URL is at the line end
+			atLineEnd = p.match(reSpaceAtEndOfLine) != nil
+		}
+	}
+	if !atLineEnd {
+		p.pos = startPos
+		return 0
+	}
+	normLabel := normalizeReference(rawLabel)
+	if normLabel == "" {
+		// label must contain non-whitespace characters
+		p.pos = startPos
+		return 0
+	}
+	if _, ok := refmap[normLabel]; !ok {
+		refmap[normLabel] = &reference{
+			destination: dest,
+			title:       title,
+		}
+	}
+	return p.pos - startPos
+}
+
+// parseHtmlTag attempts to parse a raw HTML tag, comment, processing
+// instruction, declaration or CDATA section.
+func (p *InlineParser) parseHtmlTag(block *Node) bool {
+	m := p.match(reHtmlTag)
+	if m == nil {
+		return false
+	}
+	node := p.newNode(HtmlInline)
+	node.literal = m
+	p.appendInline(block, node, p.pos-len(m))
 	return true
 }
 
 func (p *InlineParser) parseString(block *Node) bool {
-	match := reMain.Find(p.subject[p.pos:])
-	if match == nil {
+	start := p.pos
+	for p.pos < len(p.subject) && !p.special[p.subject[p.pos]] {
+		p.pos += 1
+	}
+	if p.pos == start {
 		return false
 	}
-	p.pos += len(match)
-	block.appendChild(text(match))
+	p.appendInline(block, p.text(p.subject[start:p.pos]), start)
 	return true
 }
 
 func (p *InlineParser) parseInline(block *Node) bool {
 	res := false
-	ch := p.peek()
-	if ch == 255 { // XXX: invalid char
+	if p.pos >= len(p.subject) {
 		return false
 	}
+	ch := p.subject[p.pos]
+	for _, parse := range p.extensions[ch] {
+		if parse(p, block) {
+			return true
+		}
+	}
 	switch ch {
+	case '\n':
+		res = p.parseNewline(block)
+		break
+	case '\\':
+		res = p.parseBackslash(block)
+		break
+	case '`':
+		res = p.parseBackticks(block)
+		break
 	case '*', '_':
 		res = p.handleDelim(ch, block)
 		break
+	case '[':
+		res = p.parseOpenBracket(block)
+		break
+	case '!':
+		res = p.parseBang(block)
+		break
+	case ']':
+		res = p.parseCloseBracket(block)
+		break
+	case '<':
+		res = p.parseHtmlTag(block)
+		break
+	case '&':
+		res = p.parseEntity(block)
+		break
 	default:
-		res = p.parseString(block)
+		if _, ok := p.delimTypes[ch]; ok {
+			res = p.handleDelim(ch, block)
+		} else {
+			res = p.parseString(block)
+		}
 		break
 	}
 	if !res {
 		p.pos += 1
-		block.appendChild(text([]byte{ch}))
+		p.appendInline(block, p.text(p.subject[p.pos-1:p.pos]), p.pos-1)
 	}
 	return true
 }
 
-func (p *InlineParser) processEmphasis(stackBottom *Node) {
-	// TODO
+// openersBottomKey identifies a class of closers that share a lower bound in
+// the delimiter stack for the opener search. Closers that can also open, and
+// closers of different lengths mod 3, are subject to different matching
+// restrictions (the rule of three), so they get separate bounds.
+type openersBottomKey struct {
+	cc      byte
+	canOpen bool
+	mod3    int
+}
+
+// processEmphasis matches the emphasis closers on the delimiter stack above
+// stackBottom to their openers, turning the text between them into Emph and
+// Strong nodes. All delimiters above stackBottom are removed afterwards.
+func (p *InlineParser) processEmphasis(stackBottom *delimiter) {
+	openersBottom := map[openersBottomKey]*delimiter{}
+	// find first closer above stackBottom:
+	closer := p.delimiters
+	for closer != nil && closer.previous != stackBottom {
+		closer = closer.previous
+	}
+	// move forward, looking for closers, and handling each
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+		// found emphasis closer. now look back for first matching opener:
+		key := openersBottomKey{closer.cc, closer.canOpen, closer.origDelims % 3}
+		bottom, ok := openersBottom[key]
+		if !ok {
+			bottom = stackBottom
+		}
+		opener := closer.previous
+		openerFound := false
+		for opener != nil && opener != stackBottom && opener != bottom {
+			// rule of three: if one of the delimiters can both open and
+			// close, the sum of the run lengths must not be a multiple of 3
+			// unless both lengths are
+			oddMatch := (closer.canOpen || opener.canClose) &&
+				closer.origDelims%3 != 0 &&
+				(opener.origDelims+closer.origDelims)%3 == 0
+			if opener.cc == closer.cc && opener.canOpen && !oddMatch {
+				openerFound = true
+				break
+			}
+			opener = opener.previous
+		}
+		oldCloser := closer
+		if !openerFound {
+			closer = closer.next
+		} else {
+			// calculate actual number of delimiters used from closer
+			useDelims := 1
+			if closer.numDelims >= 2 && opener.numDelims >= 2 {
+				useDelims = 2
+			}
+			openerInl := opener.node
+			closerInl := closer.node
+			// remove used delimiters from stack elts and inlines
+			opener.numDelims -= useDelims
+			closer.numDelims -= useDelims
+			openerInl.literal = openerInl.literal[:len(openerInl.literal)-useDelims]
+			closerInl.literal = closerInl.literal[:len(closerInl.literal)-useDelims]
+			// the emphasis extends over the delimiters used from the end of
+			// the opener and from the start of the closer
+			openerSpan := p.spans[openerInl]
+			closerSpan := p.spans[closerInl]
+			openerSpan.end -= useDelims
+			closerSpan.start += useDelims
+			p.spans[openerInl] = openerSpan
+			p.spans[closerInl] = closerSpan
+			// build contents for new emph element
+			emphType := Emph
+			if useDelims == 2 {
+				emphType = Strong
+			}
+			if types, ok := p.delimTypes[closer.cc]; ok {
+				emphType = types[useDelims-1]
+			}
+			emph := p.newNode(emphType)
+			p.spans[emph] = span{openerSpan.end, closerSpan.start}
+			tmp := openerInl.next
+			for tmp != nil && tmp != closerInl {
+				next := tmp.next
+				emph.appendChild(tmp)
+				tmp = next
+			}
+			openerInl.insertAfter(emph)
+			// remove elts between opener and closer in delimiters stack
+			removeDelimitersBetween(opener, closer)
+			// if opener has 0 delims, remove it and the inline
+			if opener.numDelims == 0 {
+				openerInl.Unlink()
+				p.removeDelimiter(opener)
+			}
+			if closer.numDelims == 0 {
+				closerInl.Unlink()
+				tempStack := closer.next
+				p.removeDelimiter(closer)
+				closer = tempStack
+			}
+		}
+		if !openerFound {
+			// set lower bound for future searches for openers
+			openersBottom[key] = oldCloser.previous
+			if !oldCloser.canOpen {
+				// we can remove a closer that can't be an opener, once
+				// we've seen there's no matching opener
+				p.removeDelimiter(oldCloser)
+			}
+		}
+	}
+	// remove all delimiters
+	for p.delimiters != nil && p.delimiters != stackBottom {
+		p.removeDelimiter(p.delimiters)
+	}
+}
+
+// sourcePosAt maps an offset in the subject to a line and column in the
+// source document. Offsets in the spaces that stand in for a partially
+// consumed tab map to the tab itself.
+func (p *InlineParser) sourcePosAt(offset int) (line, char uint32) {
+	lines := p.block.lineOffsets
+	if len(lines) == 0 {
+		return p.block.sourcePos.line, p.block.sourcePos.char
+	}
+	offset += p.block.dropped + p.base
+	i := sort.Search(len(lines), func(i int) bool {
+		return lines[i].offset > offset
+	}) - 1
+	if i < 0 {
+		i = 0
+	}
+	lo := lines[i]
+	d := offset - lo.offset
+	if d < lo.virtual {
+		return lo.line, lo.char - 1
+	}
+	return lo.line, lo.char + uint32(d-lo.virtual)
+}
+
+// setSourcePositions converts the recorded extents of the block's inlines
+// into source ranges.
+func (p *InlineParser) setSourcePositions(block *Node) {
+	forEachNode(block, func(node *Node, entering bool) {
+		sp, ok := p.spans[node]
+		if !ok || !entering {
+			return
+		}
+		end := sp.end - 1 // the end of a range is inclusive
+		if end < sp.start {
+			end = sp.start
+		}
+		pos := p.slab.sourceRange()
+		pos.line, pos.char = p.sourcePosAt(sp.start)
+		pos.endLine, pos.endChar = p.sourcePosAt(end)
+		node.sourcePos = pos
+	})
+	for node := range p.spans {
+		delete(p.spans, node)
+	}
 }
 
 func (p *InlineParser) parse(block *Node) {
-	p.subject = bytes.Trim(block.content, " \n\r")
+	p.subject = bytes.Trim(block.content, " \t\n\r")
 	p.pos = 0
+	p.delimiters = nil
+	p.brackets = nil
+	p.block = block
+	p.base = len(block.content) - len(bytes.TrimLeft(block.content, " \t\n\r"))
 	for p.parseInline(block) {
 	}
-	block.content = nil // allow raw string to be garbage collected
 	p.processEmphasis(nil)
+	p.setSourcePositions(block)
+	p.reset()
+	block.content = nil // allow raw string to be garbage collected
+	block.lineOffsets = nil
 }
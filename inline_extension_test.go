@@ -0,0 +1,163 @@
+package mdast_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rtfb/mdast-playground"
+)
+
+// The inline extensions here use nothing but the exported API, to show that
+// it is enough to build them outside the package.
+
+var (
+	emojiNode         = mdast.NewNodeType("Emoji", false)
+	strikethroughNode = mdast.NewNodeType("Strikethrough", true)
+)
+
+// textNode returns a Text node holding s.
+func textNode(s []byte) *mdast.Node {
+	node := mdast.NewNode(mdast.Text, nil)
+	node.SetLiteral(s)
+	return node
+}
+
+// checkLinks verifies that the parent and sibling pointers in the tree rooted
+// at root are consistent with each other.
+func checkLinks(t *testing.T, root *mdast.Node) {
+	t.Helper()
+	var prev *mdast.Node
+	for child := root.FirstChild(); child != nil; child = child.Next() {
+		if child.Parent() != root || child.Prev() != prev {
+			t.Errorf("%s: inconsistent links under %s", child.Type, root.Type)
+		}
+		checkLinks(t, child)
+		prev = child
+	}
+	if root.LastChild() != prev {
+		t.Errorf("%s: lastChild is %v, want %v", root.Type, root.LastChild(), prev)
+	}
+}
+
+// parseReference parses "@user" mentions and "#123" issue references into
+// links, when they don't follow a word character.
+func parseReference(p *mdast.InlineParser, block *mdast.Node) bool {
+	subject, pos := p.Subject(), p.Pos()
+	if pos > 0 && isWordByte(subject[pos-1]) {
+		return false
+	}
+	n := 1
+	for pos+n < len(subject) && isWordByte(subject[pos+n]) {
+		n++
+	}
+	if n == 1 {
+		return false
+	}
+	name := string(subject[pos+1 : pos+n])
+	link := mdast.NewNode(mdast.Link, nil)
+	if c, _ := p.Peek(); c == '@' {
+		link.SetDestination([]byte("/users/" + name))
+		link.SetTitle([]byte("User " + name))
+	} else {
+		link.SetDestination([]byte("/issues/" + name))
+	}
+	link.AppendChild(textNode(subject[pos : pos+n]))
+	p.AppendInline(block, link, n)
+	return true
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+var emoji = map[string]string{"smile": "\U0001F604", "tada": "\U0001F389"}
+
+func parseEmoji(p *mdast.InlineParser, block *mdast.Node) bool {
+	rest := p.Subject()[p.Pos():]
+	end := bytes.IndexByte(rest[1:], ':')
+	if end < 0 {
+		return false
+	}
+	e, ok := emoji[string(rest[1:end+1])]
+	if !ok {
+		return false
+	}
+	node := mdast.NewNode(emojiNode, nil)
+	node.SetLiteral([]byte(e))
+	p.AppendInline(block, node, end+2)
+	return true
+}
+
+var inlineHooks = map[mdast.NodeType]mdast.RenderHook{
+	emojiNode: func(w io.Writer, node *mdast.Node, entering bool) {
+		w.Write(node.Literal())
+	},
+	strikethroughNode: func(w io.Writer, node *mdast.Node, entering bool) {
+		if entering {
+			io.WriteString(w, "<del>")
+		} else {
+			io.WriteString(w, "</del>")
+		}
+	},
+}
+
+func newInlineExtendedParser() *mdast.Parser {
+	p := mdast.NewParser(mdast.Options{})
+	p.RegisterInline("@#", parseReference)
+	p.RegisterInline(":", parseEmoji)
+	p.RegisterDelimiter('~', strikethroughNode, strikethroughNode)
+	return p
+}
+
+func TestInlineExtension(t *testing.T) {
+	for _, c := range []struct {
+		input, want string
+	}{
+		{"Thanks @ann_b, see #12.", `<p>Thanks <a href="/users/ann_b" title="User ann_b">@ann_b</a>, see <a href="/issues/12">#12</a>.</p>`},
+		{"mail a@b.c, # and @ alone", "<p>mail a@b.c, # and @ alone</p>"},
+		{"*@ann* (#1)", `<p><em><a href="/users/ann" title="User ann">@ann</a></em> (<a href="/issues/1">#1</a>)</p>`},
+		{"done :tada: :nope: 10:30", "<p>done \U0001F389 :nope: 10:30</p>"},
+		{"~~gone~~ and ~this~, not ~~that~", "<p><del>gone</del> and <del>this</del>, not ~<del>that</del></p>"},
+		{"~~*a ~b~*~~", "<p><del><em>a <del>b</del></em></del></p>"},
+		{"`~~code~~ @x`", "<p><code>~~code~~ @x</code></p>"},
+	} {
+		doc, err := newInlineExtendedParser().Parse([]byte(c.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkLinks(t, doc)
+		if got := string(mdast.RenderHTML(doc, mdast.RenderOptions{Hooks: inlineHooks})); got != c.want+"\n" {
+			t.Errorf("%q rendered as\n%s\nwant\n%s", c.input, got, c.want)
+		}
+	}
+}
+
+func TestInlineExtensionSourcePos(t *testing.T) {
+	doc, err := newInlineExtendedParser().Parse([]byte("> hi @ann :smile:\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	mdast.Walk(doc, func(node *mdast.Node, entering bool) mdast.WalkStatus {
+		if entering && (node.Type == mdast.Link || node.Type == emojiNode) {
+			got = append(got, node.Type.String()+" "+node.SourcePos().String())
+		}
+		return mdast.Continue
+	})
+	if want := "Link 1:6-1:9,Emoji 1:11-1:17"; strings.Join(got, ",") != want {
+		t.Errorf("source positions are %q, want %q", strings.Join(got, ","), want)
+	}
+}
+
+func TestInlineExtensionIsPerParser(t *testing.T) {
+	newInlineExtendedParser()
+	doc, err := mdast.Parse([]byte("@ann ~~x~~ :smile:\n"), mdast.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(mdast.RenderHTML(doc, mdast.RenderOptions{})), "<p>@ann ~~x~~ :smile:</p>\n"; got != want {
+		t.Errorf("rendered as %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+package mdast_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rtfb/mdast-playground"
+)
+
+// TestRewriteLinks mutates a parsed document through the exported API only,
+// the way a program using the package would.
+func TestRewriteLinks(t *testing.T) {
+	doc, err := mdast.Parse([]byte("# Title\n\nSee [docs](/docs \"Docs\") and ![logo](logo.png).\n"), mdast.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdast.Walk(doc, func(node *mdast.Node, entering bool) mdast.WalkStatus {
+		switch {
+		case node.Type == mdast.Header:
+			node.SetLevel(2)
+		case node.Type == mdast.Link && entering:
+			node.SetDestination([]byte("https://example.com" + string(node.Destination())))
+			node.SetTitle([]byte(strings.ToUpper(string(node.Title()))))
+		case node.Type == mdast.Image && entering:
+			node.SetDestination([]byte("/static/" + string(node.Destination())))
+			node.SetTitle([]byte("Logo"))
+		}
+		return mdast.Continue
+	})
+	want := `<h2>Title</h2>
+<p>See <a href="https://example.com/docs" title="DOCS">docs</a> and <img src="/static/logo.png" alt="logo" title="Logo" />.</p>
+`
+	if got := string(mdast.RenderHTML(doc, mdast.RenderOptions{})); got != want {
+		t.Errorf("rendered as\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSetLevelOutOfRange(t *testing.T) {
+	header := mdast.NewNode(mdast.Header, nil)
+	for _, level := range []int{0, 7} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("SetLevel(%d): no panic", level)
+				}
+			}()
+			header.SetLevel(level)
+		}()
+	}
+}
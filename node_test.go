@@ -0,0 +1,220 @@
+package mdast
+
+import (
+	"strings"
+	"testing"
+)
+
+// mustParse parses input with the default options.
+func mustParse(t *testing.T, input string) *Node {
+	t.Helper()
+	doc, err := Parse([]byte(input), Options{})
+	if err != nil {
+		t.Fatalf("parse %q: %v", input, err)
+	}
+	return doc
+}
+
+// checkTree verifies that the parent and sibling pointers in the tree rooted
+// at root are consistent with each other.
+func checkTree(t *testing.T, root *Node) {
+	t.Helper()
+	var prev *Node
+	for child := root.firstChild; child != nil; child = child.next {
+		if child.parent != root {
+			t.Errorf("%s: parent is %v, want %s", child.Type, child.parent, root.Type)
+		}
+		if child.prev != prev {
+			t.Errorf("%s: prev is %v, want %v", child.Type, child.prev, prev)
+		}
+		checkTree(t, child)
+		prev = child
+	}
+	if root.lastChild != prev {
+		t.Errorf("%s: lastChild is %v, want %v", root.Type, root.lastChild, prev)
+	}
+}
+
+// types lists the types of root's children.
+func types(root *Node) string {
+	var names []string
+	for child := root.firstChild; child != nil; child = child.next {
+		names = append(names, child.Type.String())
+	}
+	return strings.Join(names, " ")
+}
+
+// walk lists the events of a walk over the tree rooted at root.
+func walk(root *Node) string {
+	var events []string
+	forEachNode(root, func(node *Node, entering bool) {
+		if !node.isContainer() {
+			events = append(events, node.Type.String())
+		} else if entering {
+			events = append(events, "+"+node.Type.String())
+		} else {
+			events = append(events, "-"+node.Type.String())
+		}
+	})
+	return strings.Join(events, " ")
+}
+
+func TestAppendPrependChild(t *testing.T) {
+	doc := NewNode(Document, nil)
+	p := NewNode(Paragraph, nil)
+	doc.AppendChild(p)
+	doc.PrependChild(NewNode(Header, nil))
+	doc.AppendChild(NewNode(HorizontalRule, nil))
+	p.PrependChild(text([]byte("b")))
+	p.PrependChild(text([]byte("a")))
+	checkTree(t, doc)
+	if got, want := types(doc), "Header Paragraph HorizontalRule"; got != want {
+		t.Errorf("children are %q, want %q", got, want)
+	}
+	if got := string(p.firstChild.literal) + string(p.lastChild.literal); got != "ab" {
+		t.Errorf("paragraph text is %q, want %q", got, "ab")
+	}
+}
+
+func TestInsertBeforeAfter(t *testing.T) {
+	doc := NewNode(Document, nil)
+	p := NewNode(Paragraph, nil)
+	doc.AppendChild(p)
+	p.InsertBefore(NewNode(Header, nil))
+	p.InsertAfter(NewNode(CodeBlock, nil))
+	p.InsertAfter(NewNode(HorizontalRule, nil))
+	doc.firstChild.InsertBefore(NewNode(BlockQuote, nil))
+	checkTree(t, doc)
+	if got, want := types(doc), "BlockQuote Header Paragraph HorizontalRule CodeBlock"; got != want {
+		t.Errorf("children are %q, want %q", got, want)
+	}
+}
+
+func TestMoveWithinTree(t *testing.T) {
+	doc := NewNode(Document, nil)
+	for _, typ := range []NodeType{Header, Paragraph, HorizontalRule} {
+		doc.AppendChild(NewNode(typ, nil))
+	}
+	// moving nodes around must unlink them from their old position
+	doc.lastChild.InsertBefore(doc.firstChild)
+	checkTree(t, doc)
+	if got, want := types(doc), "Paragraph Header HorizontalRule"; got != want {
+		t.Errorf("children are %q, want %q", got, want)
+	}
+	doc.firstChild.InsertAfter(doc.lastChild)
+	checkTree(t, doc)
+	if got, want := types(doc), "Paragraph HorizontalRule Header"; got != want {
+		t.Errorf("children are %q, want %q", got, want)
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	doc := mustParse(t, "# a\n\n> b\n\nc\n")
+	bq := doc.firstChild.next
+	bq.Unlink()
+	checkTree(t, doc)
+	checkTree(t, bq)
+	if bq.parent != nil || bq.prev != nil || bq.next != nil {
+		t.Errorf("unlinked node still has links")
+	}
+	if got, want := types(doc), "Header Paragraph"; got != want {
+		t.Errorf("children are %q, want %q", got, want)
+	}
+	if got, want := walk(bq), "+BlockQuote +Paragraph Text -Paragraph -BlockQuote"; got != want {
+		t.Errorf("detached subtree walks as %q, want %q", got, want)
+	}
+}
+
+func TestReplaceWith(t *testing.T) {
+	doc := mustParse(t, "> *a* b\n\nc\n")
+	bq := doc.firstChild
+	emph := bq.firstChild.firstChild
+	// replace a node with one from its own subtree
+	bq.ReplaceWith(emph)
+	checkTree(t, doc)
+	if got, want := types(doc), "Emph Paragraph"; got != want {
+		t.Errorf("children are %q, want %q", got, want)
+	}
+	if bq.parent != nil {
+		t.Errorf("replaced node is still linked")
+	}
+	doc.lastChild.ReplaceWith(NewNode(HorizontalRule, nil))
+	checkTree(t, doc)
+	if got, want := types(doc), "Emph HorizontalRule"; got != want {
+		t.Errorf("children are %q, want %q", got, want)
+	}
+}
+
+func TestClone(t *testing.T) {
+	doc := mustParse(t, "- [link](/u \"t\") *em*\n- two\n")
+	clone := doc.Clone()
+	checkTree(t, clone)
+	if got, want := walk(clone), walk(doc); got != want {
+		t.Errorf("clone walks as %q, want %q", got, want)
+	}
+	if got, want := string(RenderHTML(clone, RenderOptions{})), string(RenderHTML(doc, RenderOptions{})); got != want {
+		t.Errorf("clone renders as %q, want %q", got, want)
+	}
+	// the clone must be independent of the original
+	clone.firstChild.listData.tight = false
+	clone.firstChild.lastChild.Unlink()
+	checkTree(t, doc)
+	if !doc.firstChild.listData.tight {
+		t.Errorf("modifying the clone's list data changed the original")
+	}
+	if got, want := types(doc.firstChild), "Item Item"; got != want {
+		t.Errorf("original list items are %q, want %q", got, want)
+	}
+}
+
+func TestInsertIntoOwnSubtreePanics(t *testing.T) {
+	doc := mustParse(t, "> a\n")
+	bq := doc.firstChild
+	for name, f := range map[string]func(){
+		"AppendChild":  func() { bq.firstChild.AppendChild(bq) },
+		"PrependChild": func() { bq.PrependChild(bq) },
+		"InsertAfter":  func() { bq.firstChild.InsertAfter(bq) },
+		"InsertBefore": func() { bq.firstChild.firstChild.InsertBefore(doc) },
+		"ReplaceWith":  func() { bq.firstChild.ReplaceWith(doc) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic", name)
+				}
+			}()
+			f()
+		}()
+		checkTree(t, doc)
+	}
+}
+
+func TestWalkMutatedTree(t *testing.T) {
+	doc := mustParse(t, "# Title\n\nSee [docs](/docs).\n")
+	// rewrite links, inject an anchor and wrap the content in a block quote
+	forEachNode(doc, func(node *Node, entering bool) {
+		if node.Type == Link && entering {
+			node.SetDestination([]byte("https://example.com" + string(node.Destination())))
+		}
+	})
+	anchor := NewNode(HtmlBlock, nil)
+	anchor.SetLiteral([]byte(`<a id="top"></a>`))
+	doc.PrependChild(anchor)
+	bq := NewNode(BlockQuote, nil)
+	doc.lastChild.InsertAfter(bq)
+	bq.AppendChild(doc.firstChild.next.next)
+	checkTree(t, doc)
+	want := "+Document HtmlBlock +Header Text -Header +BlockQuote +Paragraph Text +Link Text -Link Text -Paragraph -BlockQuote -Document"
+	if got := walk(doc); got != want {
+		t.Errorf("walk is\n%q, want\n%q", got, want)
+	}
+	html := `<a id="top"></a>
+<h1>Title</h1>
+<blockquote>
+<p>See <a href="https://example.com/docs">docs</a>.</p>
+</blockquote>
+`
+	if got := string(RenderHTML(doc, RenderOptions{})); got != html {
+		t.Errorf("rendered as\n%s\nwant\n%s", got, html)
+	}
+}
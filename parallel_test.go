@@ -0,0 +1,178 @@
+package mdast
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// parallelInputs returns the documents used by the concurrency tests: the
+// spec examples, test.md and the input of the extension tests.
+func parallelInputs(t *testing.T) [][]byte {
+	examples, err := readSpec("testdata/spec.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inputs [][]byte
+	for _, ex := range examples {
+		inputs = append(inputs, []byte(ex.markdown))
+	}
+	testmd, err := os.ReadFile("test.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(inputs, testmd, []byte(extensionInput))
+}
+
+// render parses input with p and renders it with source positions and the
+// hooks of the extension tests.
+func render(p *Parser, input []byte) (string, error) {
+	doc, err := p.Parse(input)
+	if err != nil {
+		return "", err
+	}
+	return string(RenderHTML(doc, RenderOptions{SourcePos: true, Hooks: extensionHooks})), nil
+}
+
+func TestParallelParse(t *testing.T) {
+	inputs := parallelInputs(t)
+	want := make([]string, len(inputs))
+	for i, input := range inputs {
+		html, err := render(newExtendedParser(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[i] = html
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g += 1 {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			// each goroutine reuses a parser of its own
+			p := newExtendedParser()
+			for n := 0; n < 3; n += 1 {
+				for i := range inputs {
+					i := (i + g) % len(inputs)
+					got, err := render(p, inputs[i])
+					if err != nil {
+						t.Error(err)
+					} else if got != want[i] {
+						t.Errorf("goroutine %d: %q rendered as\n%s\nwant\n%s", g, inputs[i], got, want[i])
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestParserPool(t *testing.T) {
+	inputs := parallelInputs(t)
+	want := make([]string, len(inputs))
+	for i, input := range inputs {
+		html, err := render(newExtendedParser(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[i] = html
+	}
+	pool := sync.Pool{New: func() interface{} { return newExtendedParser() }}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g += 1 {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := range inputs {
+				i := (i * (g + 1)) % len(inputs)
+				p := pool.Get().(*Parser)
+				got, err := render(p, inputs[i])
+				p.Reset()
+				pool.Put(p)
+				if err != nil {
+					t.Error(err)
+				} else if got != want[i] {
+					t.Errorf("goroutine %d: %q rendered as\n%s\nwant\n%s", g, inputs[i], got, want[i])
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestReset(t *testing.T) {
+	p := NewParser(Options{})
+	doc, err := p.Parse([]byte("[a]\n\n[a]: /url\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Reset()
+	if p.doc == doc || p.doc.firstChild != nil || len(p.refmap) != 0 || p.input != nil || p.aliased != nil {
+		t.Errorf("Reset kept the state of the last parse")
+	}
+	if got, want := string(RenderHTML(doc, RenderOptions{})), "<p><a href=\"/url\">a</a></p>\n"; got != want {
+		t.Errorf("Reset changed the last document, rendered as %q, want %q", got, want)
+	}
+	// references don't leak from one document into the next
+	doc, err = p.Parse([]byte("[a]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(RenderHTML(doc, RenderOptions{})), "<p>[a]</p>\n"; got != want {
+		t.Errorf("rendered as %q, want %q", got, want)
+	}
+}
+
+func TestParallelNodeTypes(t *testing.T) {
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i += 1 {
+				typ := NewNodeType("Parallel", i%2 == 0)
+				node := NewNode(typ, nil)
+				if typ.String() != "Parallel" || node.isContainer() != (i%2 == 0) {
+					t.Errorf("node type %d is %s, container %t", typ, typ, node.isContainer())
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestInputUnchanged checks that building the tree doesn't write to the
+// input, which the contents of the nodes may share.
+func TestInputUnchanged(t *testing.T) {
+	inputs := []string{
+		"a\nb\n",
+		"> a\n> b\nc\n",
+		"> a\nb\n\n    code\n\tcode\n\n\n",
+		"- a\n  b\n- c\n",
+		"```\nx\n  y\n```\n",
+		"  \tx\n\ty\n",
+		"<div>\n\n</div>\n  \n",
+		"a\nb",
+		"[a]: /url\n[a]\n",
+	}
+	p := NewParser(Options{})
+	for _, in := range inputs {
+		input := []byte(in)
+		doc, err := p.Parse(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := string(RenderHTML(doc, RenderOptions{}))
+		if string(input) != in {
+			t.Errorf("%q: input changed to %q", in, input)
+		}
+		// nor when the parser goes on to another document
+		if _, err := p.Parse([]byte(strings.Repeat(in, 3))); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(RenderHTML(doc, RenderOptions{})); got != want {
+			t.Errorf("%q: rendered as %q, then %q", in, want, got)
+		}
+	}
+}
@@ -1,106 +1,342 @@
-package main
+package mdast
 
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"strconv"
 )
 
-func tag(name string, attrs []string, selfClosing bool) []byte {
-	result := "<" + name
-	if attrs != nil && len(attrs) > 0 {
-		// TODO
+// SoftbreakMode selects how soft line breaks are rendered.
+type SoftbreakMode int
+
+const (
+	SoftbreakNewline SoftbreakMode = iota // keep the line break
+	SoftbreakSpace                        // join the lines with a space
+	SoftbreakHard                         // render as a hard line break
+)
+
+// RenderHook renders the nodes of one type, writing the HTML for entering or
+// exiting node to w. The output of a block should start and end with a
+// newline, unless it is at the start of w.
+type RenderHook func(w io.Writer, node *Node, entering bool)
+
+// RenderOptions control the HTML output.
+type RenderOptions struct {
+	Softbreak SoftbreakMode
+	SourcePos bool // add data-sourcepos attributes to the elements
+	// Hooks render the nodes of the given types, in place of the standard
+	// rendering. Nodes of custom types that have no hook produce no output
+	// of their own, but their children are rendered.
+	Hooks map[NodeType]RenderHook
+}
+
+// Attr is an HTML attribute. The value is escaped when the tag is rendered.
+// Nodes carry the attributes set with SetAttr.
+type Attr struct {
+	Key   string
+	Value []byte
+}
+
+// Tag returns the HTML tag with the given name and attributes. Closing tags
+// are made by starting the name with a slash.
+func Tag(name string, attrs []Attr, selfClosing bool) []byte {
+	var buff bytes.Buffer
+	writeTag(&buff, name, attrs, selfClosing)
+	return buff.Bytes()
+}
+
+// EscapeHTML returns text with the characters that are special in HTML
+// replaced with entities.
+func EscapeHTML(text []byte) []byte {
+	if bytes.IndexAny(text, "&<>\"") < 0 {
+		return text
 	}
+	var buff bytes.Buffer
+	writeEsc(&buff, text)
+	return buff.Bytes()
+}
+
+func writeTag(buff *bytes.Buffer, name string, attrs []Attr, selfClosing bool) {
+	buff.WriteByte('<')
+	buff.WriteString(name)
+	writeAttrs(buff, attrs)
 	if selfClosing {
-		result += " /"
+		buff.WriteString(" /")
+	}
+	buff.WriteByte('>')
+}
+
+func writeAttrs(buff *bytes.Buffer, attrs []Attr) {
+	for _, attr := range attrs {
+		buff.WriteByte(' ')
+		buff.WriteString(attr.Key)
+		buff.WriteString("=\"")
+		writeEsc(buff, attr.Value)
+		buff.WriteByte('"')
 	}
-	return []byte(result + ">")
 }
 
-func render(ast *Node) []byte {
+// writeEsc writes text with the characters that are special in HTML escaped.
+// Entities are decoded by the parser, so an ampersand is always escaped.
+func writeEsc(buff *bytes.Buffer, text []byte) {
+	start := 0 // of the text not written yet
+	for i, c := range text {
+		var entity string
+		switch c {
+		case '&':
+			entity = "&amp;"
+		case '<':
+			entity = "&lt;"
+		case '>':
+			entity = "&gt;"
+		case '"':
+			entity = "&quot;"
+		default:
+			continue
+		}
+		buff.Write(text[start:i])
+		buff.WriteString(entity)
+		start = i + 1
+	}
+	buff.Write(text[start:])
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// isURISafe returns true if c can appear in a URI without being
+// percent-encoded.
+func isURISafe(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		bytes.IndexByte([]byte(";/?:@&=+$,-_.!~*'()#"), c) >= 0
+}
+
+// normalizeURI percent-encodes the bytes of uri that can't appear in a URI
+// as they are. Existing percent-encoded sequences are left alone.
+func normalizeURI(uri []byte) []byte {
+	var buff bytes.Buffer
+	for i := 0; i < len(uri); i += 1 {
+		c := uri[i]
+		if isURISafe(c) || (c == '%' && i+2 < len(uri) && isHexDigit(uri[i+1]) && isHexDigit(uri[i+2])) {
+			buff.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buff, "%%%02X", c)
+		}
+	}
+	return buff.Bytes()
+}
+
+// headerTags holds the opening and closing tag names for header levels 1 to
+// 6.
+var headerTags = [...][2]string{
+	{"h1", "/h1"}, {"h2", "/h2"}, {"h3", "/h3"}, {"h4", "/h4"}, {"h5", "/h5"}, {"h6", "/h6"},
+}
+
+// RenderHTML renders the tree rooted at ast to HTML.
+func RenderHTML(ast *Node, opts RenderOptions) []byte {
 	var buff bytes.Buffer
-	var lastOutput []byte
 	out := func(text []byte) {
 		buff.Write(text)
-		lastOutput = text
 	}
-	esc := func(text []byte, preserveEntities bool) []byte {
-		// XXX: impl
-		return text
+	disableTags := 0
+	outTag := func(name string, attrs []Attr, selfClosing bool) {
+		if disableTags == 0 {
+			writeTag(&buff, name, attrs, selfClosing)
+		}
 	}
 	cr := func() {
-		if !bytes.Equal(lastOutput, []byte("\n")) {
-			buff.WriteString("\n")
-			lastOutput = []byte("\n")
+		// Like commonmark.js, which starts with "\n" as the last output,
+		// don't put a newline before the first block: the output starts
+		// with its tag, as in the HTML of the spec examples.
+		if b := buff.Bytes(); len(b) > 0 && b[len(b)-1] != '\n' {
+			buff.WriteByte('\n')
 		}
 	}
 	forEachNode(ast, func(node *Node, entering bool) {
-		attrs := []string{}
+		if hook := opts.Hooks[node.Type]; hook != nil {
+			hook(&buff, node, entering)
+			return
+		}
+		var attrs []Attr
+		if opts.SourcePos && entering && node.sourcePos != nil {
+			attrs = append(attrs, Attr{"data-sourcepos", []byte(node.sourcePos.String())})
+		}
 		switch node.Type {
 		case Text:
-			out(esc(node.literal, false))
+			writeEsc(&buff, node.literal)
+			break
+		case Softbreak:
+			switch opts.Softbreak {
+			case SoftbreakSpace:
+				buff.WriteByte(' ')
+			case SoftbreakHard:
+				outTag("br", nil, true)
+				cr()
+			default:
+				buff.WriteByte('\n')
+			}
+			break
+		case Hardbreak:
+			outTag("br", nil, true)
+			cr()
 			break
 		case Emph:
 			if entering {
-				out(tag("em", nil, false))
+				outTag("em", append(attrs, node.attrs...), false)
 			} else {
-				out(tag("/em", nil, false))
+				outTag("/em", nil, false)
 			}
 			break
 		case Strong:
 			if entering {
-				out(tag("strong", nil, false))
+				outTag("strong", append(attrs, node.attrs...), false)
+			} else {
+				outTag("/strong", nil, false)
+			}
+			break
+		case Link:
+			if entering {
+				attrs = append(attrs, Attr{"href", normalizeURI(node.destination)})
+				if len(node.title) > 0 {
+					attrs = append(attrs, Attr{"title", node.title})
+				}
+				outTag("a", append(attrs, node.attrs...), false)
 			} else {
-				out(tag("/strong", nil, false))
+				outTag("/a", nil, false)
 			}
 			break
+		case Image:
+			// alt text is the image's content, rendered with tags disabled
+			if entering {
+				if disableTags == 0 {
+					buff.WriteString("<img src=\"")
+					writeEsc(&buff, normalizeURI(node.destination))
+					buff.WriteString("\" alt=\"")
+				}
+				disableTags += 1
+			} else {
+				disableTags -= 1
+				if disableTags == 0 {
+					if len(node.title) > 0 {
+						buff.WriteString("\" title=\"")
+						writeEsc(&buff, node.title)
+					}
+					buff.WriteByte('"')
+					writeAttrs(&buff, node.attrs)
+					buff.WriteString(" />")
+				}
+			}
+			break
+		case Code:
+			outTag("code", append(attrs, node.attrs...), false)
+			writeEsc(&buff, node.literal)
+			outTag("/code", nil, false)
+			break
+		case HtmlInline:
+			out(node.literal)
+			break
+		case HtmlBlock:
+			cr()
+			out(node.literal)
+			cr()
+			break
 		case Document:
 			break
 		case Paragraph:
-			/*
-			   grandparent = node.parent.parent;
-			   if (grandparent !== null &&
-			       grandparent.type === 'List') {
-			       if (grandparent.listTight) {
-			           break;
-			       }
-			   }
-			*/
+			// paragraphs in tight lists are rendered without <p> tags; a
+			// paragraph may also be the root, or in a list made with NewNode
+			if parent := node.parent; parent != nil {
+				if grandparent := parent.parent; grandparent != nil && grandparent.Type == List {
+					if grandparent.ListData().Tight() {
+						break
+					}
+				}
+			}
 			if entering {
 				cr()
-				out(tag("p", attrs, false))
+				outTag("p", append(attrs, node.attrs...), false)
 			} else {
-				out(tag("/p", attrs, false))
+				outTag("/p", nil, false)
 				cr()
 			}
 			break
 		case BlockQuote:
 			if entering {
 				cr()
-				out(tag("blockquote", attrs, false))
+				outTag("blockquote", append(attrs, node.attrs...), false)
 				cr()
 			} else {
 				cr()
-				out(tag("/blockquote", nil, false))
+				outTag("/blockquote", nil, false)
 				cr()
 			}
 			break
 		case Header:
-			tagname := fmt.Sprintf("h%d", node.level)
+			level := node.level
+			if level == 0 {
+				// a node that was made with another type
+				level = 1
+			}
+			tags := headerTags[level-1]
+			if entering {
+				cr()
+				outTag(tags[0], append(attrs, node.attrs...), false)
+			} else {
+				outTag(tags[1], nil, false)
+				cr()
+			}
+			break
+		case List:
+			data := node.ListData() // the zero ListData for a List made with NewNode
+			tagname, closing := "ul", "/ul"
+			if data.ListType() == OrderedList {
+				tagname, closing = "ol", "/ol"
+			}
 			if entering {
+				start := data.Start()
+				if data.ListType() == OrderedList && start != 1 {
+					attrs = append(attrs, Attr{"start", []byte(strconv.Itoa(start))})
+				}
+				cr()
+				outTag(tagname, append(attrs, node.attrs...), false)
+				cr()
+			} else {
+				cr()
+				outTag(closing, nil, false)
 				cr()
-				out(tag(tagname, attrs, false))
+			}
+			break
+		case Item:
+			if entering {
+				outTag("li", append(attrs, node.attrs...), false)
 			} else {
-				out(tag("/"+tagname, nil, false))
+				outTag("/li", nil, false)
 				cr()
 			}
 			break
+		case CodeBlock:
+			infoWords := bytes.Fields(node.info)
+			if len(infoWords) > 0 {
+				attrs = append(attrs, Attr{"class", append([]byte("language-"), infoWords[0]...)})
+			}
+			cr()
+			outTag("pre", nil, false)
+			outTag("code", append(attrs, node.attrs...), false)
+			writeEsc(&buff, node.literal)
+			outTag("/code", nil, false)
+			outTag("/pre", nil, false)
+			cr()
+			break
 		case HorizontalRule:
 			cr()
-			out(tag("hr", attrs, true))
+			outTag("hr", append(attrs, node.attrs...), true)
 			cr()
 			break
 		default:
-			panic("Unknown node type " + node.Type.String())
+			// a custom node without a render hook
+			break
 		}
 	})
 	return buff.Bytes()
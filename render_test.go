@@ -0,0 +1,432 @@
+package mdast
+
+import (
+	"strings"
+	"testing"
+)
+
+type renderCase struct {
+	input string
+	html  string
+}
+
+// checkRender parses and renders the input of each case with the given
+// options and compares the result with the expected HTML.
+func checkRender(t *testing.T, opts Options, renderOpts RenderOptions, cases []renderCase) {
+	t.Helper()
+	for _, c := range cases {
+		doc, err := Parse([]byte(c.input), opts)
+		if err != nil {
+			t.Errorf("parse %q: %v", c.input, err)
+			continue
+		}
+		if got := string(RenderHTML(doc, renderOpts)); got != c.html {
+			t.Errorf("%q renders as\n%s\nwant\n%s", c.input, got, c.html)
+		}
+	}
+}
+
+func TestTabAfterBlockMarker(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{">\tfoo\n", "<blockquote>\n<p>foo</p>\n</blockquote>\n"},
+		{">\t*q*\n", "<blockquote>\n<p><em>q</em></p>\n</blockquote>\n"},
+		{"-\tfoo\n", "<ul>\n<li>foo</li>\n</ul>\n"},
+		{"1.\t*q*\n", "<ol>\n<li><em>q</em></li>\n</ol>\n"},
+		{">\t\tfoo\n", "<blockquote>\n<pre><code>  foo\n</code></pre>\n</blockquote>\n"},
+		{"-\t\tfoo\n", "<ul>\n<li>\n<pre><code>  foo\n</code></pre>\n</li>\n</ul>\n"},
+	})
+}
+
+func TestClosingCodeFence(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"```\nx\n```\t\nafter\n", "<pre><code>x\n</code></pre>\n<p>after</p>\n"},
+		{"~~~\nx\n~~~~ \t \nafter\n", "<pre><code>x\n</code></pre>\n<p>after</p>\n"},
+		{"```\nx\n``` a\n", "<pre><code>x\n``` a\n</code></pre>\n"},
+	})
+}
+
+func TestRenderDetached(t *testing.T) {
+	doc := mustParse(t, "- a\n- b\n\n> c\n")
+	para := doc.firstChild.firstChild.firstChild
+	para.Unlink()
+	if got, want := string(RenderHTML(para, RenderOptions{})), "<p>a</p>\n"; got != want {
+		t.Errorf("detached paragraph renders as %q, want %q", got, want)
+	}
+	bqPara := doc.lastChild.firstChild
+	if got, want := string(RenderHTML(bqPara, RenderOptions{})), "<p>c</p>\n"; got != want {
+		t.Errorf("paragraph renders as %q, want %q", got, want)
+	}
+}
+
+func TestRenderNewNodes(t *testing.T) {
+	para := NewNode(Paragraph, nil)
+	para.AppendChild(text([]byte("a")))
+	if got, want := string(RenderHTML(para, RenderOptions{})), "<p>a</p>\n"; got != want {
+		t.Errorf("paragraph renders as %q, want %q", got, want)
+	}
+	list := NewNode(List, nil)
+	item := NewNode(Item, nil)
+	list.AppendChild(item)
+	item.AppendChild(para)
+	want := "<ul>\n<li>\n<p>a</p>\n</li>\n</ul>\n"
+	if got := string(RenderHTML(list, RenderOptions{})); got != want {
+		t.Errorf("list renders as %q, want %q", got, want)
+	}
+	doc := NewNode(Document, nil)
+	doc.AppendChild(list)
+	if got := string(RenderHTML(doc, RenderOptions{})); got != want {
+		t.Errorf("document renders as %q, want %q", got, want)
+	}
+	header := NewNode(Header, nil)
+	header.AppendChild(text([]byte("h")))
+	if got, want := string(RenderHTML(header, RenderOptions{})), "<h1>h</h1>\n"; got != want {
+		t.Errorf("header renders as %q, want %q", got, want)
+	}
+	para.Type = Header
+	if got, want := string(RenderHTML(para, RenderOptions{})), "<h1>a</h1>\n"; got != want {
+		t.Errorf("paragraph turned into a header renders as %q, want %q", got, want)
+	}
+}
+
+func TestRenderNoLeadingNewline(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"a\n", "<p>a</p>\n"},
+		{"# a\n", "<h1>a</h1>\n"},
+		{"<div>\n", "<div>\n"},
+		{"---\n", "<hr />\n"},
+		{"> a\n", "<blockquote>\n<p>a</p>\n</blockquote>\n"},
+		{"", ""},
+	})
+}
+
+func TestLineEndings(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"a\r\nb\r\n", "<p>a\nb</p>\n"},
+		{"a\rb\r", "<p>a\nb</p>\n"},
+		{"a\r\n\r\nb", "<p>a</p>\n<p>b</p>\n"},
+		{"a  \r\nb\\\r\nc\r\n", "<p>a<br />\nb<br />\nc</p>\n"},
+		{"# a #\r\nb\r\n===\r\n", "<h1>a</h1>\n<h1>b</h1>\n"},
+		{"```go\r\ncode\r\n\r\n```\r\n", "<pre><code class=\"language-go\">code\n\n</code></pre>\n"},
+		{"    code\r\n    more\r\n", "<pre><code>code\nmore\n</code></pre>\n"},
+		{"> a\r> b\r\n\r\n- c\r\n- d\r\n", "<blockquote>\n<p>a\nb</p>\n</blockquote>\n<ul>\n<li>c</li>\n<li>d</li>\n</ul>\n"},
+		{"<div>\r\nx\r\n</div>\r\n", "<div>\nx\n</div>\n"},
+		{"[a]\r\n\r\n[a]: /u\r\n  'title'\r\n", "<p><a href=\"/u\" title=\"title\">a</a></p>\n"},
+		{"\r\n", ""},
+		{"\r", ""},
+	})
+	checkRender(t, Options{}, RenderOptions{SourcePos: true}, []renderCase{
+		{"a\r\nbc\r\n", "<p data-sourcepos=\"1:1-2:2\">a\nbc</p>\n"},
+	})
+}
+
+func TestSetextHeaders(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"Title\n=====\n", "<h1>Title</h1>\n"},
+		{"Sub\n---\n", "<h2>Sub</h2>\n"},
+		{"Sub\n  ---  \n", "<h2>Sub</h2>\n"},
+		{"a\nb\n===\n", "<h1>a\nb</h1>\n"},
+		// the whitespace around the content of a header is trimmed
+		{" a\t\n===\n", "<h1>a</h1>\n"},
+		{"a\t\n", "<p>a</p>\n"},
+		// without a paragraph to underline, --- is a horizontal rule
+		{"---\n", "<hr />\n"},
+		{"- a\n---\n", "<ul>\n<li>a</li>\n</ul>\n<hr />\n"},
+		{"a\n\n---\n", "<p>a</p>\n<hr />\n"},
+		// an underline can't be a lazy continuation line
+		{"> a\n---\n", "<blockquote>\n<p>a</p>\n</blockquote>\n<hr />\n"},
+		{"> a\n===\n", "<blockquote>\n<p>a\n===</p>\n</blockquote>\n"},
+		// a paragraph of nothing but reference definitions can't be underlined
+		{"[r]: /u\n===\n", "<p>===</p>\n"},
+		{"[r]: /u\n[r]\n---\n", "<h2><a href=\"/u\">r</a></h2>\n"},
+	})
+	doc := mustParse(t, "a\n===\n\nb\n---\n")
+	if got, want := types(doc), "Header Header"; got != want {
+		t.Fatalf("children are %q, want %q", got, want)
+	}
+	if h1, h2 := doc.firstChild.Level(), doc.lastChild.Level(); h1 != 1 || h2 != 2 {
+		t.Errorf("levels are %d and %d, want 1 and 2", h1, h2)
+	}
+}
+
+func TestLazyContinuation(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"> a\nb\n", "<blockquote>\n<p>a\nb</p>\n</blockquote>\n"},
+		{"> a\n> b\nc\n", "<blockquote>\n<p>a\nb\nc</p>\n</blockquote>\n"},
+		{"> - a\nb\n", "<blockquote>\n<ul>\n<li>a\nb</li>\n</ul>\n</blockquote>\n"},
+		// only paragraphs continue lazily
+		{"> ```\na\n", "<blockquote>\n<pre><code></code></pre>\n</blockquote>\n<p>a</p>\n"},
+		{">     code\n    lazy\n", "<blockquote>\n<pre><code>code\n</code></pre>\n</blockquote>\n<pre><code>lazy\n</code></pre>\n"},
+		{"> a\n\nb\n", "<blockquote>\n<p>a</p>\n</blockquote>\n<p>b</p>\n"},
+	})
+}
+
+func TestHtmlBlocks(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		// type 1 ends at the closing tag, even past blank lines
+		{"<script>\nx\n\ny\n</script>\nz\n", "<script>\nx\n\ny\n</script>\n<p>z</p>\n"},
+		// types 2 to 5 end at their closing sequences
+		{"<!-- c\n\n-->\n*a*\n", "<!-- c\n\n-->\n<p><em>a</em></p>\n"},
+		{"<?php x ?>\n*a*\n", "<?php x ?>\n<p><em>a</em></p>\n"},
+		{"<!DOCTYPE html>\n*a*\n", "<!DOCTYPE html>\n<p><em>a</em></p>\n"},
+		{"<![CDATA[\nx\n]]>\n*a*\n", "<![CDATA[\nx\n]]>\n<p><em>a</em></p>\n"},
+		// types 6 and 7 end at a blank line
+		{"<div>\n*a*\n\n*b*\n", "<div>\n*a*\n<p><em>b</em></p>\n"},
+		{"<details open>\n<summary>s</summary>\n", "<details open>\n<summary>s</summary>\n"},
+		{"<custom-tag>\n\nx\n", "<custom-tag>\n<p>x</p>\n"},
+		// only types 1 to 6 can interrupt a paragraph
+		{"a\n<div>\n", "<p>a</p>\n<div>\n"},
+		{"a\n<custom-tag>\n", "<p>a\n<custom-tag></p>\n"},
+		{"    <div>\n", "<pre><code>&lt;div&gt;\n</code></pre>\n"},
+	})
+}
+
+func TestRawHTMLInline(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"a <b class=\"x\">c</b> <!-- d --> <?p q?> <!X y> <![CDATA[z]]>\n",
+			"<p>a <b class=\"x\">c</b> <!-- d --> <?p q?> <!X y> <![CDATA[z]]></p>\n"},
+		// not tags, so escaped as text
+		{"a <b c=> <3 <a\n", "<p>a &lt;b c=&gt; &lt;3 &lt;a</p>\n"},
+		{"`<b>`\n", "<p><code>&lt;b&gt;</code></p>\n"},
+	})
+}
+
+func TestRawHTMLModes(t *testing.T) {
+	checkRender(t, Options{RawHTML: RawHTMLDrop}, RenderOptions{}, []renderCase{
+		{"<div>\n*a*\n\n*b*\n", "<p><em>b</em></p>\n"},
+		{"<!-- c\n\n-->\n", ""},
+		{"a <b class=\"x\">c</b> <!-- d -->\n", "<p>a c </p>\n"},
+	})
+	checkRender(t, Options{RawHTML: RawHTMLEscape}, RenderOptions{}, []renderCase{
+		{"<div>\n*a*\n\n*b*\n", "<p>&lt;div&gt;\n*a*</p>\n<p><em>b</em></p>\n"},
+		{"<script>\nx\n</script>\n", "<p>&lt;script&gt;\nx\n&lt;/script&gt;</p>\n"},
+		{"a <b class=\"x\">c</b> <!-- d -->\n", "<p>a &lt;b class=&quot;x&quot;&gt;c&lt;/b&gt; &lt;!-- d --&gt;</p>\n"},
+	})
+}
+
+func TestCodeSpans(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"`a`\n", "<p><code>a</code></p>\n"},
+		{"`` a`b ``\n", "<p><code>a`b</code></p>\n"},
+		{"` `` `\n", "<p><code>``</code></p>\n"},
+		{"`  a  `\n", "<p><code> a </code></p>\n"},
+		{"` `\n", "<p><code> </code></p>\n"},
+		{"`a\nb  c`\n", "<p><code>a b  c</code></p>\n"},
+		{"```a``\n", "<p>```a``</p>\n"},
+		{"`a\\*b` `<&>`\n", "<p><code>a\\*b</code> <code>&lt;&amp;&gt;</code></p>\n"},
+		{"*a `*` b*\n", "<p><em>a <code>*</code> b</em></p>\n"},
+	})
+}
+
+func TestBackslashEscapes(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"\\*a\\* \\_ \\\\ \\` \\# \\< \\&amp;\n", "<p>*a* _ \\ ` # &lt; &amp;amp;</p>\n"},
+		// only ASCII punctuation can be escaped
+		{"\\a \\é \\\n", "<p>\\a \\é \\</p>\n"},
+		{"[a](/u\\*1 \"t\\\"\")\n", "<p><a href=\"/u*1\" title=\"t&quot;\">a</a></p>\n"},
+		{"```\\*\nx\n```\n", "<pre><code class=\"language-*\">x\n</code></pre>\n"},
+	})
+}
+
+func TestEntities(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"&amp; &copy; &Auml; &nbsp;x\n", "<p>&amp; © Ä \u00a0x</p>\n"},
+		{"&#35; &#x22; &#X1F600; &#0;\n", "<p># &quot; \U0001F600 \uFFFD</p>\n"},
+		// not entities, so the ampersand is escaped
+		{"&bogus; &#1234567890; & &amp\n", "<p>&amp;bogus; &amp;#1234567890; &amp; &amp;amp</p>\n"},
+		{"`&amp;`\n", "<p><code>&amp;amp;</code></p>\n"},
+		{"[a](/u?x=1&amp;y \"t&quot;\")\n", "<p><a href=\"/u?x=1&amp;y\" title=\"t&quot;\">a</a></p>\n"},
+		{"```&lt;\nx\n```\n", "<pre><code class=\"language-&lt;\">x\n</code></pre>\n"},
+	})
+	// entities that stand for two code points
+	got := ""
+	for node := mustParse(t, "&ngE; &#x41;\n").firstChild.firstChild; node != nil; node = node.next {
+		got += string(node.literal)
+	}
+	if want := "\u2267\u0338 A"; got != want {
+		t.Errorf("decoded to %q, want %q", got, want)
+	}
+}
+
+func TestLineBreaks(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"a\nb\n", "<p>a\nb</p>\n"},
+		{"a  \nb\n", "<p>a<br />\nb</p>\n"},
+		{"a \nb\n", "<p>a\nb</p>\n"},
+		{"a\\\nb\n", "<p>a<br />\nb</p>\n"},
+		{"a     \n   b\n", "<p>a<br />\nb</p>\n"},
+		// not at the end of a paragraph or a header
+		{"a  \n", "<p>a</p>\n"},
+		{"a\\\n", "<p>a\\</p>\n"},
+		{"# a  \n", "<h1>a</h1>\n"},
+		{"`a  \nb`\n", "<p><code>a   b</code></p>\n"},
+	})
+	input := "a\nb  \nc\n"
+	checkRender(t, Options{}, RenderOptions{Softbreak: SoftbreakSpace}, []renderCase{
+		{input, "<p>a b<br />\nc</p>\n"},
+	})
+	checkRender(t, Options{}, RenderOptions{Softbreak: SoftbreakHard}, []renderCase{
+		{input, "<p>a<br />\nb<br />\nc</p>\n"},
+	})
+	doc := mustParse(t, input)
+	if got, want := walk(doc), "+Document +Paragraph Text Softbreak Text Hardbreak Text -Paragraph -Document"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+}
+
+func TestEscapeHTML(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"", ""},
+		{"plain", "plain"},
+		{`<script>alert("&")</script>`, "&lt;script&gt;alert(&quot;&amp;&quot;)&lt;/script&gt;"},
+		{"&amp; 'q'", "&amp;amp; 'q'"},
+	} {
+		if got := string(EscapeHTML([]byte(c.in))); got != c.want {
+			t.Errorf("EscapeHTML(%q) is %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRenderNodeAttrs(t *testing.T) {
+	doc := mustParse(t, "# a\n\n- *b* ![c](/i)\n\n```go\nd\n```\n")
+	forEachNode(doc, func(node *Node, entering bool) {
+		if entering && node.Type != Text {
+			node.SetAttr("id", []byte(node.Type.String()))
+		}
+	})
+	doc.FirstChild().SetAttr("id", []byte(`"a"`))
+	doc.FirstChild().SetAttr("class", []byte("x"))
+	want := "<h1 id=\"&quot;a&quot;\" class=\"x\">a</h1>\n" +
+		"<ul id=\"List\">\n<li id=\"Item\"><em id=\"Emph\">b</em> " +
+		"<img src=\"/i\" alt=\"c\" id=\"Image\" /></li>\n</ul>\n" +
+		"<pre><code class=\"language-go\" id=\"CodeBlock\">d\n</code></pre>\n"
+	if got := string(RenderHTML(doc, RenderOptions{})); got != want {
+		t.Errorf("rendered as\n%s\nwant\n%s", got, want)
+	}
+	clone := doc.FirstChild().Clone()
+	clone.SetAttr("class", []byte("y"))
+	if got := string(doc.FirstChild().Attrs()[1].Value); got != "x" {
+		t.Errorf("setting an attribute of a clone changed the original to %q", got)
+	}
+}
+
+func TestTag(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		attrs       []Attr
+		selfClosing bool
+		want        string
+	}{
+		{"p", nil, false, "<p>"},
+		{"/p", nil, false, "</p>"},
+		{"hr", nil, true, "<hr />"},
+		{"a", []Attr{{"href", []byte("/u?a=1&b=2")}, {"title", []byte(`"x" <y>`)}}, false,
+			`<a href="/u?a=1&amp;b=2" title="&quot;x&quot; &lt;y&gt;">`},
+		{"img", []Attr{{"alt", nil}}, true, `<img alt="" />`},
+	} {
+		if got := string(Tag(c.name, c.attrs, c.selfClosing)); got != c.want {
+			t.Errorf("Tag(%q) is %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestNormalizeURI(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"/a/b?c=d&e=f#g", "/a/b?c=d&e=f#g"},
+		{"a b", "a%20b"},
+		{"%20%zz%4", "%20%25zz%254"},
+		{"ä\"<>\\`", "%C3%A4%22%3C%3E%5C%60"},
+		{"mailto:a@b.c", "mailto:a@b.c"},
+	} {
+		if got := string(normalizeURI([]byte(c.in))); got != c.want {
+			t.Errorf("normalizeURI(%q) is %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRenderEscaping(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"a & b < c > d \"e\"\n", "<p>a &amp; b &lt; c &gt; d &quot;e&quot;</p>\n"},
+		{"[a](</u v> \"<t>\")\n", "<p><a href=\"/u%20v\" title=\"&lt;t&gt;\">a</a></p>\n"},
+		{"![*a* & `b`](/i.png \"t\")\n", "<p><img src=\"/i.png\" alt=\"a &amp; b\" title=\"t\" /></p>\n"},
+		{"```a\"b\n<x>\n```\n", "<pre><code class=\"language-a&quot;b\">&lt;x&gt;\n</code></pre>\n"},
+	})
+}
+
+// inlinePositions lists the types and source positions of the inline nodes
+// in the tree rooted at root.
+func inlinePositions(root *Node) string {
+	var positions []string
+	forEachNode(root, func(node *Node, entering bool) {
+		switch node.Type {
+		case Document, BlockQuote, List, Item, Paragraph, Header, HorizontalRule, CodeBlock, HtmlBlock:
+			break
+		default:
+			if entering {
+				positions = append(positions, node.Type.String()+" "+node.SourcePos().String())
+			}
+		}
+	})
+	return strings.Join(positions, ", ")
+}
+
+func TestRenderSourcePos(t *testing.T) {
+	input := "# a *b*\n\n> c `d`\n> - e\n>\n> 1. f\n\n    g\n***\n```\nh\n```\n[i](/u)\n"
+	want := `<h1 data-sourcepos="1:1-1:7">a <em data-sourcepos="1:5-1:7">b</em></h1>
+<blockquote data-sourcepos="3:1-6:6">
+<p data-sourcepos="3:3-3:7">c <code data-sourcepos="3:5-3:7">d</code></p>
+<ul data-sourcepos="4:3-5:1">
+<li data-sourcepos="4:3-5:1">e</li>
+</ul>
+<ol data-sourcepos="6:3-6:6">
+<li data-sourcepos="6:3-6:6">f</li>
+</ol>
+</blockquote>
+<pre><code data-sourcepos="8:5-8:5">g
+</code></pre>
+<hr data-sourcepos="9:1-9:3" />
+<pre><code data-sourcepos="10:1-12:3">h
+</code></pre>
+<p data-sourcepos="13:1-13:7"><a data-sourcepos="13:1-13:7" href="/u">i</a></p>
+`
+	checkRender(t, Options{}, RenderOptions{SourcePos: true}, []renderCase{{input, want}})
+	// without the option, there are no attributes
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{{"# a *b*\n", "<h1>a <em>b</em></h1>\n"}})
+	doc := mustParse(t, "a *b* `c`\nd\\\n[e](/u)\n")
+	got := inlinePositions(doc)
+	if want := "Text 1:1-1:2, Emph 1:3-1:5, Text 1:4-1:4, Text 1:6-1:6, Code 1:7-1:9, Softbreak 1:10-1:10, " +
+		"Text 2:1-2:1, Hardbreak 2:2-2:3, Link 3:1-3:7, Text 3:2-3:2"; got != want {
+		t.Errorf("inline positions are\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestInlineSourcePos(t *testing.T) {
+	for _, c := range []struct{ input, want string }{
+		// block quote and list item prefixes
+		{"> > ab *c*\n> > d\n", "Text 1:5-1:7, Emph 1:8-1:10, Text 1:9-1:9, Softbreak 1:11-1:11, Text 2:5-2:5"},
+		{"- a\n\n  b `c`\n", "Text 1:3-1:3, Text 3:3-3:4, Code 3:5-3:7"},
+		{"> a\nlazy *b*\n", "Text 1:3-1:3, Softbreak 1:4-1:4, Text 2:1-2:5, Emph 2:6-2:8, Text 2:7-2:7"},
+		// tabs
+		{">\tab\n", "Text 1:3-1:4"},
+		{"-\tab\n", "Text 1:3-1:4"},
+		{"# \t h *i* ##\n", "Text 1:5-1:6, Emph 1:7-1:9, Text 1:8-1:8"},
+		// leading and trailing spaces
+		{"   ab  \n  cd\n", "Text 1:4-1:5, Hardbreak 1:6-1:8, Text 2:3-2:4"},
+		{"a\n  b\n===\n", "Text 1:1-1:1, Softbreak 1:2-1:2, Text 2:3-2:3"},
+		// reference definitions stripped from the paragraph
+		{"[r]: /u\nab\n", "Text 2:1-2:2"},
+		{"> [r]: /u\n> ab *c*\n", "Text 2:3-2:5, Emph 2:6-2:8, Text 2:7-2:7"},
+	} {
+		if got := inlinePositions(mustParse(t, c.input)); got != c.want {
+			t.Errorf("%q: inline positions are\n%s\nwant\n%s", c.input, got, c.want)
+		}
+	}
+}
+
+func TestByte255(t *testing.T) {
+	checkRender(t, Options{}, RenderOptions{}, []renderCase{
+		{"*\xff tail text here*\n", "<p><em>\xff tail text here</em></p>\n"},
+		{"a\n\xffb more\n", "<p>a\n\xffb more</p>\n"},
+		{"[x](/u\xffv)\n", "<p><a href=\"/u%FFv\">x</a></p>\n"},
+		{"\xff\n", "<p>\xff</p>\n"},
+	})
+}
@@ -0,0 +1,140 @@
+package mdast
+
+import (
+	"regexp"
+	"testing"
+)
+
+// The regexps the byte scanners replaced, as in commonmark.js.
+var (
+	reATXHeaderMarker   = regexp.MustCompile("^#{1,6}(?:[ \t]+|$)")
+	reATXClosingOnly    = regexp.MustCompile("^[ \t]*#+[ \t]*$")
+	reATXClosing        = regexp.MustCompile("[ \t]+#+[ \t]*$")
+	reHrule             = regexp.MustCompile("^(?:(?:\\*[ \t]*){3,}|(?:_[ \t]*){3,}|(?:-[ \t]*){3,})$")
+	reMain              = regexp.MustCompile("^[^\\n`\\[\\]\\\\!<&*_'\"]+")
+	reTrailingBlanks    = regexp.MustCompile("(\n *)+$")
+	reNonSpace          = regexp.MustCompile("[^ \t\f\v\r\n]")
+	reLine              = regexp.MustCompile("^([^\r\n]*)(\r\n|\r|\n)?")
+	reOrderedListMarker = regexp.MustCompile("^(\\d{1,9})([.)])")
+	reClosingCodeFence  = regexp.MustCompile("^(?:`{3,}|~{3,})[ \t]*$")
+	reSetextHeaderLine  = regexp.MustCompile("^(?:=+|-+)[ \t]*$")
+)
+
+// scanInputs returns all the strings of up to n characters from alphabet.
+func scanInputs(alphabet string, n int) []string {
+	inputs := []string{""}
+	last := []string{""}
+	for i := 0; i < n; i += 1 {
+		var next []string
+		for _, s := range last {
+			for j := 0; j < len(alphabet); j += 1 {
+				next = append(next, s+alphabet[j:j+1])
+			}
+		}
+		inputs = append(inputs, next...)
+		last = next
+	}
+	return inputs
+}
+
+func TestScanATXHeaderMarker(t *testing.T) {
+	for _, s := range scanInputs("# a\t", 8) {
+		length, level := scanATXHeaderMarker([]byte(s))
+		match := reATXHeaderMarker.FindString(s)
+		if length != len(match) {
+			t.Errorf("%q: marker length is %d, want %d", s, length, len(match))
+		} else if match != "" && level != len(regexp.MustCompile("^#+").FindString(s)) {
+			t.Errorf("%q: level is %d", s, level)
+		}
+	}
+}
+
+func TestTrimATXClosing(t *testing.T) {
+	for _, s := range scanInputs("# a\\\t", 7) {
+		want := reATXClosing.ReplaceAllString(reATXClosingOnly.ReplaceAllString(s, ""), "")
+		if got := string(trimATXClosing([]byte(s))); got != want {
+			t.Errorf("%q: trimmed to %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestIsHrule(t *testing.T) {
+	for _, s := range scanInputs("*-_ a\t", 6) {
+		if got, want := isHrule([]byte(s)), reHrule.MatchString(s); got != want {
+			t.Errorf("%q: isHrule is %t, want %t", s, got, want)
+		}
+	}
+}
+
+func TestTrailingBlankLines(t *testing.T) {
+	for _, s := range scanInputs(" \na", 8) {
+		want := len(s)
+		if loc := reTrailingBlanks.FindStringIndex(s); loc != nil {
+			want = loc[0]
+		}
+		if got := trailingBlankLines([]byte(s)); got != want {
+			t.Errorf("%q: blank lines start at %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestScanUnderline(t *testing.T) {
+	for _, s := range scanInputs("=-`~ \ta", 6) {
+		for _, c := range []byte("=-`~") {
+			count := scanUnderline([]byte(s), c)
+			re, match := reSetextHeaderLine, count > 0
+			if c == '`' || c == '~' {
+				re, match = reClosingCodeFence, count >= 3
+			}
+			if want := re.MatchString(s) && s[0] == c; match != want {
+				t.Errorf("%q: %d %cs, match is %t, want %t", s, count, c, match, want)
+			}
+		}
+	}
+}
+
+func TestScanOrderedListMarker(t *testing.T) {
+	inputs := append(scanInputs("10.)a", 5), "123456789.", "1234567890.", "123456789)a")
+	for _, s := range inputs {
+		want := 0
+		if m := reOrderedListMarker.FindStringSubmatch(s); m != nil {
+			want = len(m[1])
+		}
+		if got := scanOrderedListMarker([]byte(s)); got != want {
+			t.Errorf("%q: %d digits, want %d", s, got, want)
+		}
+	}
+}
+
+func TestIsBlank(t *testing.T) {
+	for _, s := range scanInputs(" \t\r\n\va", 5) {
+		if got, want := isBlank([]byte(s)), !reNonSpace.MatchString(s); got != want {
+			t.Errorf("%q: isBlank is %t, want %t", s, got, want)
+		}
+	}
+}
+
+func TestScanLine(t *testing.T) {
+	for _, s := range scanInputs("\r\na", 5) {
+		m := reLine.FindStringSubmatch(s)
+		if length, ending := scanLine([]byte(s)); length != len(m[1]) || ending != len(m[2]) {
+			t.Errorf("%q: line and ending are %d and %d long, want %d and %d", s, length, ending, len(m[1]), len(m[2]))
+		}
+	}
+}
+
+func TestParseString(t *testing.T) {
+	p := newInlineParser()
+	for _, s := range scanInputs("a*\n\xff<é", 4) {
+		p.subject = []byte(s)
+		p.pos = 0
+		block := NewNode(Paragraph, nil)
+		got := ""
+		if p.parseString(block) {
+			got = string(block.firstChild.literal)
+		}
+		if want := reMain.FindString(s); got != want {
+			t.Errorf("%q: parsed %q, want %q", s, got, want)
+		}
+	}
+}
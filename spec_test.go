@@ -0,0 +1,138 @@
+package mdast
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// knownFailures lists the numbers of the examples in testdata/spec.txt that
+// the parser doesn't pass yet. They are skipped, and reported as errors once
+// they start passing, so that they get removed from here.
+var knownFailures = map[int]bool{
+	20:  true, // autolinks
+	346: true, // autolinks
+	480: true, // autolinks
+	481: true, // autolinks
+	526: true, // autolinks
+	538: true, // autolinks
+	540: true, // Unicode case folding of link labels
+	594: true, // autolinks
+	595: true, // autolinks
+	596: true, // autolinks
+	597: true, // autolinks
+	598: true, // autolinks
+	599: true, // autolinks
+	600: true, // autolinks
+	601: true, // autolinks
+	603: true, // autolinks
+	604: true, // autolinks
+	605: true, // autolinks
+}
+
+type specExample struct {
+	number   int
+	section  string
+	markdown string
+	html     string
+}
+
+const specFence = "````````````````````````````````"
+
+// readSpec extracts the examples from a file in the format of the CommonMark
+// spec.txt. testdata/spec.txt holds the examples of version 0.31.2, so the
+// example numbers are those of the spec; the spec.txt of a new version can
+// replace it as it is.
+func readSpec(path string) ([]specExample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var examples []specExample
+	var ex *specExample
+	section := ""
+	inHTML := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case ex == nil && strings.HasPrefix(line, specFence+" example"):
+			ex = &specExample{number: len(examples) + 1, section: section}
+			inHTML = false
+		case ex == nil && strings.HasPrefix(line, "#"):
+			section = strings.TrimSpace(strings.TrimLeft(line, "#"))
+		case ex == nil:
+			break
+		case line == specFence:
+			examples = append(examples, *ex)
+			ex = nil
+		case line == "." && !inHTML:
+			inHTML = true
+		case inHTML:
+			ex.html += strings.Replace(line, "→", "\t", -1) + "\n"
+		default:
+			ex.markdown += strings.Replace(line, "→", "\t", -1) + "\n"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if ex != nil {
+		return nil, fmt.Errorf("%s: example %d is not terminated", path, ex.number)
+	}
+	return examples, nil
+}
+
+func TestSpec(t *testing.T) {
+	examples, err := readSpec("testdata/spec.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type count struct {
+		passed, total int
+	}
+	var sections []string
+	counts := map[string]*count{}
+	for _, ex := range examples {
+		ex := ex
+		c := counts[ex.section]
+		if c == nil {
+			c = &count{}
+			counts[ex.section] = c
+			sections = append(sections, ex.section)
+		}
+		c.total += 1
+		t.Run(fmt.Sprint(ex.number), func(t *testing.T) {
+			doc, err := Parse([]byte(ex.markdown), Options{})
+			var got string
+			if err == nil {
+				got = string(RenderHTML(doc, RenderOptions{}))
+			}
+			if err == nil && got == ex.html {
+				c.passed += 1
+				if knownFailures[ex.number] {
+					t.Errorf("example %d (%s) passes, remove it from knownFailures", ex.number, ex.section)
+				}
+				return
+			}
+			if knownFailures[ex.number] {
+				t.Skipf("known failure")
+			}
+			if err != nil {
+				t.Errorf("example %d (%s): %v", ex.number, ex.section, err)
+				return
+			}
+			t.Errorf("example %d (%s):\n%s\ngot:\n%s\nwant:\n%s", ex.number, ex.section, ex.markdown, got, ex.html)
+		})
+	}
+	passed := 0
+	for _, section := range sections {
+		c := counts[section]
+		passed += c.passed
+		t.Logf("%-45s %3d/%3d", section, c.passed, c.total)
+	}
+	t.Logf("%-45s %3d/%3d", "Total", passed, len(examples))
+}
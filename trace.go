@@ -0,0 +1,49 @@
+package mdast
+
+import (
+	"fmt"
+	"io"
+)
+
+// Tracer receives events about the progress of a parse. Set Options.Tracer to
+// debug how a document gets parsed.
+type Tracer interface {
+	// Line is called for each input line before it is incorporated into the
+	// document.
+	Line(lineNumber int, line []byte)
+	// OpenBlock is called when a block is added to the document.
+	OpenBlock(node *Node)
+	// CloseBlock is called when a block is finalized. Its source position is
+	// complete, but a paragraph may still be removed from the document after
+	// this if it only contained link reference definitions.
+	CloseBlock(node *Node)
+	// Delimiter is called when a run of emphasis delimiters is pushed on the
+	// delimiter stack. The node holds the run as its literal.
+	Delimiter(node *Node, canOpen, canClose bool)
+}
+
+type textTracer struct {
+	w io.Writer
+}
+
+// NewTextTracer returns a Tracer that writes a line of text about each event
+// to w.
+func NewTextTracer(w io.Writer) Tracer {
+	return &textTracer{w: w}
+}
+
+func (t *textTracer) Line(lineNumber int, line []byte) {
+	fmt.Fprintf(t.w, "%3d: %s\n", lineNumber, line)
+}
+
+func (t *textTracer) OpenBlock(node *Node) {
+	fmt.Fprintf(t.w, "     open %s\n", node.Type)
+}
+
+func (t *textTracer) CloseBlock(node *Node) {
+	fmt.Fprintf(t.w, "     close %s %s\n", node.Type, node.SourcePos())
+}
+
+func (t *textTracer) Delimiter(node *Node, canOpen, canClose bool) {
+	fmt.Fprintf(t.w, "     delimiter %q open=%t close=%t\n", node.literal, canOpen, canClose)
+}
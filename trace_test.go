@@ -0,0 +1,48 @@
+package mdast
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTextTracer(t *testing.T) {
+	var buff bytes.Buffer
+	if _, err := Parse([]byte("> *a*\n\nb\n"), Options{Tracer: NewTextTracer(&buff)}); err != nil {
+		t.Fatal(err)
+	}
+	want := `  1: > *a*
+     open BlockQuote
+     open Paragraph
+  2: 
+     close Paragraph 1:3-1:5
+     close BlockQuote 1:1-1:5
+  3: b
+     open Paragraph
+     close Paragraph 3:1-3:1
+     close Document 1:1-3:1
+     delimiter "*" open=true close=false
+     delimiter "*" open=false close=true
+`
+	if got := buff.String(); got != want {
+		t.Errorf("trace is\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTextTracerSetextHeader(t *testing.T) {
+	var buff bytes.Buffer
+	if _, err := Parse([]byte("a\nb\n===\n"), Options{Tracer: NewTextTracer(&buff)}); err != nil {
+		t.Fatal(err)
+	}
+	want := `  1: a
+     open Paragraph
+  2: b
+  3: ===
+     close Paragraph 1:1-2:1
+     open Header
+     close Header 1:1-3:3
+     close Document 1:1-3:3
+`
+	if got := buff.String(); got != want {
+		t.Errorf("trace is\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,228 @@
+package mdast
+
+// NodeWalker iterates over the events of a depth-first walk of a tree: every
+// node is entered, and containers are also exited after their children. The
+// walk never leaves the tree it was started on, even when its root has a
+// parent or siblings.
+type NodeWalker struct {
+	root     *Node
+	current  *Node
+	entering bool
+	started  bool
+	// parent and next of the current node at the time it was returned, so
+	// that the walk can carry on if the node gets unlinked or moved.
+	parent *Node
+	next   *Node
+}
+
+func NewNodeWalker(root *Node) *NodeWalker {
+	return &NodeWalker{
+		root:     root,
+		current:  root,
+		entering: true,
+	}
+}
+
+// Next returns the next node of the walk and whether it is being entered or
+// exited. The first event enters the root and, if the root is a container,
+// the last one exits it. Next returns nil when the walk is over.
+//
+// Between calls, the caller may modify the node that was returned last and the
+// nodes that were already visited. If the last node got unlinked or moved to
+// another parent, the walk continues from where it used to be; nodes inserted
+// in its place are not visited.
+func (nw *NodeWalker) Next() (*Node, bool) {
+	if !nw.started {
+		nw.started = true
+		nw.set(nw.current, nw.entering)
+		return nw.current, nw.entering
+	}
+	if nw.current == nil {
+		return nil, false
+	}
+	if nw.moved() {
+		if nw.next != nil {
+			nw.current = nw.next
+			nw.entering = true
+		} else {
+			nw.current = nw.parent
+			nw.entering = false
+		}
+	} else if nw.entering && nw.current.isContainer() {
+		if nw.current.firstChild != nil {
+			nw.current = nw.current.firstChild
+			nw.entering = true
+		} else {
+			nw.entering = false
+		}
+	} else if nw.current == nw.root {
+		nw.current = nil
+	} else if nw.current.next == nil {
+		nw.current = nw.current.parent
+		nw.entering = false
+	} else {
+		nw.current = nw.current.next
+		nw.entering = true
+	}
+	if nw.current == nil {
+		return nil, false
+	}
+	nw.set(nw.current, nw.entering)
+	return nw.current, nw.entering
+}
+
+// ResumeAt makes the walk continue as though Next had just returned node and
+// entering. Resuming at a container with entering set to false skips its
+// children. The node must be within the tree being walked.
+func (nw *NodeWalker) ResumeAt(node *Node, entering bool) {
+	nw.started = true
+	nw.set(node, entering)
+}
+
+// moved returns true if the current node was unlinked or moved to another
+// parent since it was returned.
+func (nw *NodeWalker) moved() bool {
+	return nw.current != nw.root && nw.current.parent != nw.parent
+}
+
+func (nw *NodeWalker) set(node *Node, entering bool) {
+	nw.current = node
+	nw.entering = entering
+	if node != nil {
+		nw.parent = node.parent
+		nw.next = node.next
+	}
+}
+
+// WalkStatus tells Walk how to carry on after visiting a node.
+type WalkStatus int
+
+const (
+	// Continue goes on with the walk as usual.
+	Continue WalkStatus = iota
+	// SkipChildren doesn't descend into the node that was just entered. Its
+	// exit is skipped as well. When returned on exit, it is the same as
+	// Continue.
+	SkipChildren
+	// Stop ends the walk.
+	Stop
+)
+
+// Walk calls f for every event of a walk over the tree rooted at root. The
+// callback may modify the tree as described for NodeWalker.Next.
+func Walk(root *Node, f func(node *Node, entering bool) WalkStatus) {
+	walker := NewNodeWalker(root)
+	for node, entering := walker.Next(); node != nil; node, entering = walker.Next() {
+		switch f(node, entering) {
+		case SkipChildren:
+			// a node that was moved has left its children behind already;
+			// Next carries on from the successor saved before the callback
+			if entering && !walker.moved() {
+				walker.ResumeAt(node, false)
+			}
+			break
+		case Stop:
+			return
+		}
+	}
+}
+
+func forEachNode(root *Node, f func(node *Node, entering bool)) {
+	Walk(root, func(node *Node, entering bool) WalkStatus {
+		f(node, entering)
+		return Continue
+	})
+}
+
+// Visitor has a method for each node type, called with entering set to true
+// when the walk enters a node of that type and to false when it exits it.
+// Leaf nodes are only entered. Embed BaseVisitor to implement only the
+// methods of interest.
+type Visitor interface {
+	Document(node *Node, entering bool) WalkStatus
+	BlockQuote(node *Node, entering bool) WalkStatus
+	List(node *Node, entering bool) WalkStatus
+	Item(node *Node, entering bool) WalkStatus
+	Paragraph(node *Node, entering bool) WalkStatus
+	Header(node *Node, entering bool) WalkStatus
+	HorizontalRule(node *Node, entering bool) WalkStatus
+	CodeBlock(node *Node, entering bool) WalkStatus
+	HtmlBlock(node *Node, entering bool) WalkStatus
+	Emph(node *Node, entering bool) WalkStatus
+	Strong(node *Node, entering bool) WalkStatus
+	Link(node *Node, entering bool) WalkStatus
+	Image(node *Node, entering bool) WalkStatus
+	Code(node *Node, entering bool) WalkStatus
+	Softbreak(node *Node, entering bool) WalkStatus
+	Hardbreak(node *Node, entering bool) WalkStatus
+	Text(node *Node, entering bool) WalkStatus
+	HtmlInline(node *Node, entering bool) WalkStatus
+}
+
+// BaseVisitor implements Visitor by continuing the walk at every node.
+type BaseVisitor struct{}
+
+func (BaseVisitor) Document(node *Node, entering bool) WalkStatus       { return Continue }
+func (BaseVisitor) BlockQuote(node *Node, entering bool) WalkStatus     { return Continue }
+func (BaseVisitor) List(node *Node, entering bool) WalkStatus           { return Continue }
+func (BaseVisitor) Item(node *Node, entering bool) WalkStatus           { return Continue }
+func (BaseVisitor) Paragraph(node *Node, entering bool) WalkStatus      { return Continue }
+func (BaseVisitor) Header(node *Node, entering bool) WalkStatus         { return Continue }
+func (BaseVisitor) HorizontalRule(node *Node, entering bool) WalkStatus { return Continue }
+func (BaseVisitor) CodeBlock(node *Node, entering bool) WalkStatus      { return Continue }
+func (BaseVisitor) HtmlBlock(node *Node, entering bool) WalkStatus      { return Continue }
+func (BaseVisitor) Emph(node *Node, entering bool) WalkStatus           { return Continue }
+func (BaseVisitor) Strong(node *Node, entering bool) WalkStatus         { return Continue }
+func (BaseVisitor) Link(node *Node, entering bool) WalkStatus           { return Continue }
+func (BaseVisitor) Image(node *Node, entering bool) WalkStatus          { return Continue }
+func (BaseVisitor) Code(node *Node, entering bool) WalkStatus           { return Continue }
+func (BaseVisitor) Softbreak(node *Node, entering bool) WalkStatus      { return Continue }
+func (BaseVisitor) Hardbreak(node *Node, entering bool) WalkStatus      { return Continue }
+func (BaseVisitor) Text(node *Node, entering bool) WalkStatus           { return Continue }
+func (BaseVisitor) HtmlInline(node *Node, entering bool) WalkStatus     { return Continue }
+
+// WalkVisitor walks the tree rooted at root, calling the method of v that
+// matches the type of each node.
+func WalkVisitor(root *Node, v Visitor) {
+	Walk(root, func(node *Node, entering bool) WalkStatus {
+		switch node.Type {
+		case Document:
+			return v.Document(node, entering)
+		case BlockQuote:
+			return v.BlockQuote(node, entering)
+		case List:
+			return v.List(node, entering)
+		case Item:
+			return v.Item(node, entering)
+		case Paragraph:
+			return v.Paragraph(node, entering)
+		case Header:
+			return v.Header(node, entering)
+		case HorizontalRule:
+			return v.HorizontalRule(node, entering)
+		case CodeBlock:
+			return v.CodeBlock(node, entering)
+		case HtmlBlock:
+			return v.HtmlBlock(node, entering)
+		case Emph:
+			return v.Emph(node, entering)
+		case Strong:
+			return v.Strong(node, entering)
+		case Link:
+			return v.Link(node, entering)
+		case Image:
+			return v.Image(node, entering)
+		case Code:
+			return v.Code(node, entering)
+		case Softbreak:
+			return v.Softbreak(node, entering)
+		case Hardbreak:
+			return v.Hardbreak(node, entering)
+		case Text:
+			return v.Text(node, entering)
+		case HtmlInline:
+			return v.HtmlInline(node, entering)
+		}
+		return Continue
+	})
+}
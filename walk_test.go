@@ -0,0 +1,171 @@
+package mdast
+
+import (
+	"strings"
+	"testing"
+)
+
+// events lists the events of a walk over the tree rooted at root, stopping or
+// skipping children as status decides.
+func events(root *Node, status func(node *Node, entering bool) WalkStatus) string {
+	var events []string
+	Walk(root, func(node *Node, entering bool) WalkStatus {
+		if !node.isContainer() {
+			events = append(events, node.Type.String())
+		} else if entering {
+			events = append(events, "+"+node.Type.String())
+		} else {
+			events = append(events, "-"+node.Type.String())
+		}
+		return status(node, entering)
+	})
+	return strings.Join(events, " ")
+}
+
+func TestWalkSkipChildren(t *testing.T) {
+	doc := mustParse(t, "> *a*\n\n    code\n\nb\n")
+	got := events(doc, func(node *Node, entering bool) WalkStatus {
+		if node.Type == BlockQuote {
+			return SkipChildren
+		}
+		return Continue
+	})
+	if want := "+Document +BlockQuote CodeBlock +Paragraph Text -Paragraph -Document"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+}
+
+func TestWalkStop(t *testing.T) {
+	doc := mustParse(t, "a *b* c\n\nd\n")
+	got := events(doc, func(node *Node, entering bool) WalkStatus {
+		if node.Type == Emph && !entering {
+			return Stop
+		}
+		return Continue
+	})
+	if want := "+Document +Paragraph Text +Emph Text -Emph"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+}
+
+func TestWalkUnlinkCurrent(t *testing.T) {
+	doc := mustParse(t, "# a\n\n<div>\n\nb <i>c</i>\n")
+	var visited []string
+	Walk(doc, func(node *Node, entering bool) WalkStatus {
+		if node.Type == HtmlBlock || node.Type == HtmlInline {
+			node.Unlink()
+		} else if node.Type == Text {
+			visited = append(visited, string(node.literal))
+		}
+		return Continue
+	})
+	checkTree(t, doc)
+	if got, want := strings.Join(visited, ","), "a,b ,c"; got != want {
+		t.Errorf("visited %q, want %q", got, want)
+	}
+	if got, want := walk(doc), "+Document +Header Text -Header +Paragraph Text Text -Paragraph -Document"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+}
+
+func TestWalkLeafRoot(t *testing.T) {
+	doc := mustParse(t, "a *b* c\n\nd\n")
+	para := doc.firstChild
+	for _, leaf := range []*Node{para.firstChild, para.firstChild.next.firstChild, para.lastChild} {
+		if got, want := walk(leaf), "Text"; got != want {
+			t.Errorf("walk of %q is %q, want %q", leaf.literal, got, want)
+		}
+	}
+	code := mustParse(t, "    code\n\n---\n").firstChild
+	if got, want := walk(code), "CodeBlock"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+}
+
+func TestWalkEmptyContainer(t *testing.T) {
+	doc := NewNode(Document, nil)
+	if got, want := walk(doc), "+Document -Document"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+	// an empty item followed by a sibling, in a list followed by a paragraph
+	doc = mustParse(t, "-\n- a\n\nb\n")
+	item := doc.firstChild.firstChild
+	if got, want := walk(item), "+Item -Item"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+}
+
+func TestWalkMidTree(t *testing.T) {
+	doc := mustParse(t, "# a\n\n> b *c*\n>\n> - d\n\ne\n")
+	bq := doc.firstChild.next
+	if got, want := walk(bq), "+BlockQuote +Paragraph Text +Emph Text -Emph -Paragraph +List +Item +Paragraph Text -Paragraph -Item -List -BlockQuote"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+	emph := bq.firstChild.lastChild
+	if got, want := walk(emph), "+Emph Text -Emph"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+	// skipping the children of the root ends the walk
+	got := events(bq, func(node *Node, entering bool) WalkStatus {
+		return SkipChildren
+	})
+	if want := "+BlockQuote"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+	// unlinking the root keeps the walk within it
+	got = events(bq, func(node *Node, entering bool) WalkStatus {
+		if node == bq && entering {
+			bq.Unlink()
+		}
+		return Continue
+	})
+	if want := "+BlockQuote +Paragraph Text +Emph Text -Emph -Paragraph +List +Item +Paragraph Text -Paragraph -Item -List -BlockQuote"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+}
+
+type codeCounter struct {
+	BaseVisitor
+	blocks, spans int
+}
+
+func (c *codeCounter) CodeBlock(node *Node, entering bool) WalkStatus {
+	c.blocks++
+	return Continue
+}
+
+func (c *codeCounter) Code(node *Node, entering bool) WalkStatus {
+	c.spans++
+	return Continue
+}
+
+func (c *codeCounter) BlockQuote(node *Node, entering bool) WalkStatus {
+	return SkipChildren
+}
+
+func TestWalkVisitor(t *testing.T) {
+	doc := mustParse(t, "`a` and `b`\n\n```\nc\n```\n\n> `d`\n>\n>     e\n")
+	c := &codeCounter{}
+	WalkVisitor(doc, c)
+	if c.blocks != 1 || c.spans != 2 {
+		t.Errorf("counted %d code blocks and %d code spans, want 1 and 2", c.blocks, c.spans)
+	}
+}
+
+func TestWalkUnlinkSkipChildren(t *testing.T) {
+	doc := mustParse(t, "> a\n\nb\n\nc\n")
+	got := events(doc, func(node *Node, entering bool) WalkStatus {
+		if node.Type == BlockQuote {
+			node.Unlink()
+			return SkipChildren
+		}
+		return Continue
+	})
+	if want := "+Document +BlockQuote +Paragraph Text -Paragraph +Paragraph Text -Paragraph -Document"; got != want {
+		t.Errorf("walk is %q, want %q", got, want)
+	}
+	checkTree(t, doc)
+	if got, want := types(doc), "Paragraph Paragraph"; got != want {
+		t.Errorf("children are %q, want %q", got, want)
+	}
+}